@@ -1,11 +1,14 @@
 package chanrpc
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"gitee.com/aarlin/leaflet/conf"
 	"gitee.com/aarlin/leaflet/log"
+	"gitee.com/aarlin/leaflet/metrics"
 	"runtime"
+	"time"
 )
 
 // one server per goroutine (goroutine not safe)
@@ -17,11 +20,18 @@ type Server struct {
 	// func(args []interface{})
 	// func(args []interface{}) interface{}
 	// func(args []interface{}) []interface{}
-	functions map[interface{}]interface{}
-	ChanCall  chan *CallInfo
+	functions  map[interface{}]interface{}
+	signatures map[interface{}]signature
+	ChanCall   chan *CallInfo
+
+	// labels the metrics of the server
+	Name       string
+	execTime   map[interface{}]*metrics.Histogram
+	execErrors *metrics.Counter
 }
 
 type CallInfo struct {
+	id      interface{}
 	f       interface{}
 	args    []interface{}
 	chanRet chan *RetInfo
@@ -46,12 +56,20 @@ type Client struct {
 	chanSyncRet     chan *RetInfo
 	ChanAsynRet     chan *RetInfo
 	pendingAsynCall int
+
+	// the default timeout of the synchronous calls, 0 means none
+	Timeout time.Duration
 }
 
+// returned by the synchronous calls which passed their deadline
+var ErrTimeout = errors.New("chanrpc call timeout")
+
 func NewServer(l int) *Server {
 	s := new(Server)
 	s.functions = make(map[interface{}]interface{})
+	s.signatures = make(map[interface{}]signature)
 	s.ChanCall = make(chan *CallInfo, l)
+	s.execTime = make(map[interface{}]*metrics.Histogram)
 	return s
 }
 
@@ -128,12 +146,33 @@ func (s *Server) exec(ci *CallInfo) (err error) {
 }
 
 func (s *Server) Exec(ci *CallInfo) {
+	start := time.Now()
 	err := s.exec(ci)
+	s.observe(ci.id, time.Since(start), err)
 	if err != nil {
 		log.Error("%v", err)
 	}
 }
 
+func (s *Server) observe(id interface{}, d time.Duration, err error) {
+	h := s.execTime[id]
+	if h == nil {
+		h = metrics.NewHistogram("chanrpc_exec_seconds",
+			"time spent by Server.Exec per function id", nil,
+			"server", s.Name, "id", fmt.Sprint(id))
+		s.execTime[id] = h
+	}
+	h.Observe(d.Seconds())
+
+	if err != nil {
+		if s.execErrors == nil {
+			s.execErrors = metrics.NewCounter("chanrpc_exec_errors_total",
+				"calls that panicked or failed to return", "server", s.Name)
+		}
+		s.execErrors.Inc()
+	}
+}
+
 // goroutine safe
 func (s *Server) Go(id interface{}, args ...interface{}) {
 	f := s.functions[id]
@@ -146,6 +185,7 @@ func (s *Server) Go(id interface{}, args ...interface{}) {
 	}()
 
 	s.ChanCall <- &CallInfo{
+		id:   id,
 		f:    f,
 		args: args,
 	}
@@ -176,6 +216,21 @@ func (s *Server) Close() {
 	}
 }
 
+// goroutine safe
+func (s *Server) CallContext0(ctx context.Context, id interface{}, args ...interface{}) error {
+	return s.Open(0).CallContext0(ctx, id, args...)
+}
+
+// goroutine safe
+func (s *Server) CallContext1(ctx context.Context, id interface{}, args ...interface{}) (interface{}, error) {
+	return s.Open(0).CallContext1(ctx, id, args...)
+}
+
+// goroutine safe
+func (s *Server) CallContextN(ctx context.Context, id interface{}, args ...interface{}) ([]interface{}, error) {
+	return s.Open(0).CallContextN(ctx, id, args...)
+}
+
 // goroutine safe
 func (s *Server) Open(l int) *Client {
 	c := NewClient(l)
@@ -207,6 +262,8 @@ func (c *Client) call(ci *CallInfo, block bool) (err error) {
 		select {
 		case c.s.ChanCall <- ci:
 		default:
+			metrics.NewCounter("chanrpc_channel_full_total",
+				"calls rejected because ChanCall is full", "server", c.s.Name).Inc()
 			err = errors.New("chanrpc channel full")
 		}
 	}
@@ -244,60 +301,114 @@ func (c *Client) f(id interface{}, n int) (f interface{}, err error) {
 }
 
 func (c *Client) Call0(id interface{}, args ...interface{}) error {
-	f, err := c.f(id, 0)
-	if err != nil {
-		return err
-	}
+	return c.CallContext0(context.Background(), id, args...)
+}
 
-	err = c.call(&CallInfo{
-		f:       f,
-		args:    args,
-		chanRet: c.chanSyncRet,
-	}, true)
+func (c *Client) Call1(id interface{}, args ...interface{}) (interface{}, error) {
+	return c.CallContext1(context.Background(), id, args...)
+}
+
+func (c *Client) CallN(id interface{}, args ...interface{}) ([]interface{}, error) {
+	return c.CallContextN(context.Background(), id, args...)
+}
+
+// the call is abandoned when ctx is done, Timeout applies if ctx
+// has no deadline
+func (c *Client) CallContext0(ctx context.Context, id interface{}, args ...interface{}) error {
+	ri, err := c.syncCall(ctx, id, 0, args)
 	if err != nil {
 		return err
 	}
-
-	ri := <-c.chanSyncRet
 	return ri.err
 }
 
-func (c *Client) Call1(id interface{}, args ...interface{}) (interface{}, error) {
-	f, err := c.f(id, 1)
+func (c *Client) CallContext1(ctx context.Context, id interface{}, args ...interface{}) (interface{}, error) {
+	ri, err := c.syncCall(ctx, id, 1, args)
 	if err != nil {
 		return nil, err
 	}
+	return ri.ret, ri.err
+}
 
-	err = c.call(&CallInfo{
-		f:       f,
-		args:    args,
-		chanRet: c.chanSyncRet,
-	}, true)
+func (c *Client) CallContextN(ctx context.Context, id interface{}, args ...interface{}) ([]interface{}, error) {
+	ri, err := c.syncCall(ctx, id, 2, args)
 	if err != nil {
 		return nil, err
 	}
-
-	ri := <-c.chanSyncRet
-	return ri.ret, ri.err
+	return assert(ri.ret), ri.err
 }
 
-func (c *Client) CallN(id interface{}, args ...interface{}) ([]interface{}, error) {
-	f, err := c.f(id, 2)
+func (c *Client) syncCall(ctx context.Context, id interface{}, n int, args []interface{}) (*RetInfo, error) {
+	f, err := c.f(id, n)
 	if err != nil {
 		return nil, err
 	}
 
-	err = c.call(&CallInfo{
+	if _, ok := ctx.Deadline(); !ok && c.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Timeout)
+		defer cancel()
+	}
+
+	if ctx.Done() == nil {
+		err = c.call(&CallInfo{
+			id:      id,
+			f:       f,
+			args:    args,
+			chanRet: c.chanSyncRet,
+		}, true)
+		if err != nil {
+			return nil, err
+		}
+		return <-c.chanSyncRet, nil
+	}
+
+	// a reply coming after the call is abandoned goes to chanRet,
+	// which has room for it and is dropped with it
+	if ctx.Err() != nil {
+		return nil, c.ctxErr(ctx)
+	}
+	chanRet := make(chan *RetInfo, 1)
+	err = c.callContext(ctx, &CallInfo{
+		id:      id,
 		f:       f,
 		args:    args,
-		chanRet: c.chanSyncRet,
-	}, true)
+		chanRet: chanRet,
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	ri := <-c.chanSyncRet
-	return assert(ri.ret), ri.err
+	select {
+	case ri := <-chanRet:
+		return ri, nil
+	case <-ctx.Done():
+		return nil, c.ctxErr(ctx)
+	}
+}
+
+func (c *Client) callContext(ctx context.Context, ci *CallInfo) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = r.(error)
+		}
+	}()
+
+	select {
+	case c.s.ChanCall <- ci:
+	case <-ctx.Done():
+		err = c.ctxErr(ctx)
+	}
+	return
+}
+
+func (c *Client) ctxErr(ctx context.Context) error {
+	if ctx.Err() == context.DeadlineExceeded {
+		metrics.NewCounter("chanrpc_call_timeouts_total",
+			"synchronous calls abandoned after their deadline", "server", c.s.Name).Inc()
+		return ErrTimeout
+	}
+	return ctx.Err()
 }
 
 func (c *Client) asynCall(id interface{}, args []interface{}, cb interface{}, n int) {
@@ -308,6 +419,7 @@ func (c *Client) asynCall(id interface{}, args []interface{}, cb interface{}, n
 	}
 
 	err = c.call(&CallInfo{
+		id:      id,
 		f:       f,
 		args:    args,
 		chanRet: c.ChanAsynRet,
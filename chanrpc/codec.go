@@ -0,0 +1,136 @@
+package chanrpc
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"reflect"
+)
+
+// encodes the arguments and the return values of remote calls
+type Codec interface {
+	// must goroutine safe
+	Marshal(args []interface{}) ([]byte, error)
+	// must goroutine safe
+	Unmarshal(data []byte) ([]interface{}, error)
+}
+
+// gob codec
+//
+// the concrete types of the arguments must be registered on both sides
+type GobCodec struct{}
+
+func NewGobCodec() *GobCodec {
+	return new(GobCodec)
+}
+
+// you must call the function before calling Marshal or Unmarshal
+func (c *GobCodec) Register(v interface{}) {
+	gob.Register(v)
+}
+
+func (c *GobCodec) Marshal(args []interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(args)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (c *GobCodec) Unmarshal(data []byte) ([]interface{}, error) {
+	var args []interface{}
+	err := gob.NewDecoder(bytes.NewReader(data)).Decode(&args)
+	return args, err
+}
+
+// json codec
+//
+// ---------------------------------
+// | [{"t": type, "v": value}, ...] |
+// ---------------------------------
+type JSONCodec struct {
+	types map[string]reflect.Type
+}
+
+type jsonArg struct {
+	T string          `json:"t,omitempty"`
+	V json.RawMessage `json:"v"`
+}
+
+func NewJSONCodec() *JSONCodec {
+	c := new(JSONCodec)
+	c.types = make(map[string]reflect.Type)
+	for _, v := range []interface{}{
+		false,
+		int(0), int8(0), int16(0), int32(0), int64(0),
+		uint(0), uint8(0), uint16(0), uint32(0), uint64(0),
+		float32(0), float64(0),
+		"", []byte(nil),
+		[]interface{}(nil), map[string]interface{}(nil),
+	} {
+		c.Register(v)
+	}
+	return c
+}
+
+// you must call the function before calling Marshal or Unmarshal
+func (c *JSONCodec) Register(v interface{}) {
+	t := reflect.TypeOf(v)
+	if t == nil {
+		panic("json codec: invalid type")
+	}
+	c.types[t.String()] = t
+}
+
+func (c *JSONCodec) Marshal(args []interface{}) ([]byte, error) {
+	jsonArgs := make([]jsonArg, len(args))
+	for i, arg := range args {
+		if arg == nil {
+			jsonArgs[i].V = json.RawMessage("null")
+			continue
+		}
+
+		t := reflect.TypeOf(arg).String()
+		if _, ok := c.types[t]; !ok {
+			return nil, fmt.Errorf("json codec: type %v not registered", t)
+		}
+		v, err := json.Marshal(arg)
+		if err != nil {
+			return nil, err
+		}
+		jsonArgs[i].T = t
+		jsonArgs[i].V = v
+	}
+
+	return json.Marshal(jsonArgs)
+}
+
+func (c *JSONCodec) Unmarshal(data []byte) ([]interface{}, error) {
+	var jsonArgs []jsonArg
+	err := json.Unmarshal(data, &jsonArgs)
+	if err != nil {
+		return nil, err
+	}
+
+	args := make([]interface{}, len(jsonArgs))
+	for i, arg := range jsonArgs {
+		if arg.T == "" {
+			continue
+		}
+
+		t, ok := c.types[arg.T]
+		if !ok {
+			return nil, fmt.Errorf("json codec: type %v not registered", arg.T)
+		}
+		v := reflect.New(t)
+		err := json.Unmarshal(arg.V, v.Interface())
+		if err != nil {
+			return nil, err
+		}
+		args[i] = v.Elem().Interface()
+	}
+
+	return args, nil
+}
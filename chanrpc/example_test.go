@@ -1,9 +1,11 @@
 package chanrpc_test
 
 import (
+	"context"
 	"fmt"
 	"gitee.com/aarlin/leaflet/chanrpc"
 	"sync"
+	"time"
 )
 
 func Example() {
@@ -125,3 +127,156 @@ func Example() {
 	// 1 2 3
 	// 3
 }
+
+func ExampleDial() {
+	s := chanrpc.NewServer(10)
+	s.Register("add", func(args []interface{}) interface{} {
+		return args[0].(int) + args[1].(int)
+	})
+	s.Register("swap", func(args []interface{}) []interface{} {
+		return []interface{}{args[1], args[0]}
+	})
+	s.Register("fail", func(args []interface{}) {
+		panic("failed")
+	})
+	chanrpc.Handle(s, "half", func(n int) (int, error) {
+		if n%2 != 0 {
+			return 0, fmt.Errorf("%v is odd", n)
+		}
+		return n / 2, nil
+	})
+	go func() {
+		for ci := range s.ChanCall {
+			s.Exec(ci)
+		}
+	}()
+
+	// process 1
+	rs := new(chanrpc.RemoteServer)
+	rs.Addr = "127.0.0.1:37201"
+	rs.Codec = chanrpc.NewJSONCodec()
+	rs.Publish("game", s)
+	rs.Start()
+	defer rs.Close()
+
+	// process 2
+	remote, err := chanrpc.Dial("127.0.0.1:37201", "game", chanrpc.NewJSONCodec())
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer remote.Close()
+
+	c := remote.Open(10)
+
+	r1, err := c.Call1("add", 1, 2)
+	fmt.Println(r1, err)
+
+	rn, err := c.CallN("swap", "a", "b")
+	fmt.Println(rn, err)
+
+	fmt.Println(c.Call0("fail"))
+
+	// the errors of the typed functions come as their messages
+	half, err := chanrpc.Call[int, int](c, "half", 4)
+	fmt.Println(half, err)
+	_, err = chanrpc.Call[int, int](c, "half", 3)
+	fmt.Println(err)
+
+	c.AsynCall("add", 3, 4, func(ret interface{}, err error) {
+		fmt.Println(ret, err)
+	})
+	c.Cb(<-c.ChanAsynRet)
+
+	_, err = chanrpc.Dial("127.0.0.1:37201", "db", chanrpc.NewGobCodec())
+	fmt.Println(err)
+
+	// Output:
+	// 3 <nil>
+	// [b a] <nil>
+	// failed
+	// 2 <nil>
+	// 3 is odd
+	// 7 <nil>
+	// server db: not published
+}
+
+func ExampleClient_CallContext1() {
+	s := chanrpc.NewServer(10)
+	block := make(chan bool)
+	s.Register("echo", func(args []interface{}) interface{} {
+		if args[0] == "slow" {
+			<-block
+		}
+		return args[0]
+	})
+	go func() {
+		for ci := range s.ChanCall {
+			s.Exec(ci)
+		}
+	}()
+
+	c := s.Open(0)
+	c.Timeout = 50 * time.Millisecond
+
+	_, err := c.Call1("echo", "slow")
+	fmt.Println(err == chanrpc.ErrTimeout)
+
+	// the late reply of the slow call is dropped
+	close(block)
+	ret, err := c.Call1("echo", "fast")
+	fmt.Println(ret, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = c.CallContext1(ctx, "echo", "fast")
+	fmt.Println(err)
+
+	// Output:
+	// true
+	// fast <nil>
+	// context canceled
+}
+
+type Login struct {
+	Name string
+}
+
+type LoginRet struct {
+	ID int
+}
+
+func ExampleHandle() {
+	s := chanrpc.NewServer(10)
+	chanrpc.Handle(s, "login", func(req *Login) (*LoginRet, error) {
+		if req.Name == "" {
+			return nil, fmt.Errorf("empty name")
+		}
+		return &LoginRet{ID: 42}, nil
+	})
+	go func() {
+		for ci := range s.ChanCall {
+			s.Exec(ci)
+		}
+	}()
+
+	ret, err := chanrpc.Call[*Login, *LoginRet](s, "login", &Login{Name: "leaf"})
+	fmt.Println(ret.ID, err)
+
+	_, err = chanrpc.Call[*Login, *LoginRet](s, "login", &Login{})
+	fmt.Println(err)
+
+	// the types are checked before the call is sent
+	_, err = chanrpc.Call[string, int](s, "login", "leaf")
+	fmt.Println(err)
+
+	// untyped callers still work
+	rn, err := s.CallN("login", 1)
+	fmt.Println(rn[1], err)
+
+	// Output:
+	// 42 <nil>
+	// empty name
+	// function id login: handler is func(*chanrpc_test.Login) (*chanrpc_test.LoginRet, error), called as func(string) (int, error)
+	// function id login: want argument *chanrpc_test.Login, got int <nil>
+}
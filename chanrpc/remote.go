@@ -0,0 +1,514 @@
+package chanrpc
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"gitee.com/aarlin/leaflet/log"
+	"gitee.com/aarlin/leaflet/network"
+	"io"
+	"math"
+	"sync"
+	"time"
+)
+
+// remote message types
+const (
+	remoteHandshake = iota
+	remoteGo
+	remoteCall0
+	remoteCall1
+	remoteCallN
+	remoteRet
+)
+
+const remoteHandshakeTimeout = 10 * time.Second
+
+// ----------------------------------------------
+// | type | seq | len | id | len | err | data |
+// ----------------------------------------------
+//
+// handshake: id is the server name, data lists the functions
+// go, call:  id is the function id, data holds the arguments
+// ret:       data holds the return values
+type remoteMsg struct {
+	t    uint8
+	seq  uint64
+	id   string
+	err  string
+	data []byte
+}
+
+func (m *remoteMsg) marshal() []byte {
+	b := make([]byte, 1+3*binary.MaxVarintLen64+len(m.id)+len(m.err)+len(m.data))
+	b[0] = m.t
+	n := 1
+	n += binary.PutUvarint(b[n:], m.seq)
+	n += binary.PutUvarint(b[n:], uint64(len(m.id)))
+	n += copy(b[n:], m.id)
+	n += binary.PutUvarint(b[n:], uint64(len(m.err)))
+	n += copy(b[n:], m.err)
+	n += copy(b[n:], m.data)
+	return b[:n]
+}
+
+func readString(b []byte) (string, []byte, error) {
+	l, n := binary.Uvarint(b)
+	if n <= 0 || uint64(len(b)-n) < l {
+		return "", nil, io.ErrUnexpectedEOF
+	}
+	return string(b[n : n+int(l)]), b[n+int(l):], nil
+}
+
+func unmarshalRemoteMsg(b []byte) (*remoteMsg, error) {
+	if len(b) < 1 {
+		return nil, io.ErrUnexpectedEOF
+	}
+	m := new(remoteMsg)
+	m.t = b[0]
+	b = b[1:]
+
+	var n int
+	m.seq, n = binary.Uvarint(b)
+	if n <= 0 {
+		return nil, io.ErrUnexpectedEOF
+	}
+	b = b[n:]
+
+	var err error
+	m.id, b, err = readString(b)
+	if err != nil {
+		return nil, err
+	}
+	m.err, b, err = readString(b)
+	if err != nil {
+		return nil, err
+	}
+	m.data = b
+	return m, nil
+}
+
+func remoteType(f interface{}) uint8 {
+	switch f.(type) {
+	case func([]interface{}):
+		return remoteCall0
+	case func([]interface{}) interface{}:
+		return remoteCall1
+	case func([]interface{}) []interface{}:
+		return remoteCallN
+	}
+
+	panic("bug")
+}
+
+func newMsgParser() network.TcpParser {
+	p := network.NewMsgParser()
+	p.SetMsgLen(4, 1, math.MaxUint32)
+	return p
+}
+
+// RemoteServer publishes servers over tcp
+//
+// only functions with string ids are reachable remotely
+type RemoteServer struct {
+	Addr            string
+	MaxConnNum      int
+	PendingWriteNum int
+	Codec           Codec
+	servers         map[string]*Server
+	tcpServer       *network.TCPServer
+}
+
+type remoteCall struct {
+	t   uint8
+	seq uint64
+	id  string
+}
+
+type remoteAgent struct {
+	rs      *RemoteServer
+	conn    *network.TCPConn
+	server  *Server
+	chanRet chan *RetInfo
+}
+
+// you must call the function before calling Start
+func (rs *RemoteServer) Publish(name string, s *Server) {
+	if rs.servers == nil {
+		rs.servers = make(map[string]*Server)
+	}
+	if _, ok := rs.servers[name]; ok {
+		panic(fmt.Sprintf("server %v: already published", name))
+	}
+
+	rs.servers[name] = s
+}
+
+func (rs *RemoteServer) Start() {
+	if rs.Codec == nil {
+		log.FatalF("Codec must not be nil")
+	}
+	if rs.PendingWriteNum <= 0 {
+		rs.PendingWriteNum = 100
+		log.ReleaseF("invalid PendingWriteNum, reset to %v", rs.PendingWriteNum)
+	}
+
+	rs.tcpServer = new(network.TCPServer)
+	rs.tcpServer.Addr = rs.Addr
+	rs.tcpServer.MaxConnNum = rs.MaxConnNum
+	rs.tcpServer.PendingWriteNum = rs.PendingWriteNum
+	rs.tcpServer.TcpParser = newMsgParser()
+	rs.tcpServer.NewAgent = func(conn *network.TCPConn) network.Agent {
+		a := new(remoteAgent)
+		a.rs = rs
+		a.conn = conn
+		a.chanRet = make(chan *RetInfo, rs.PendingWriteNum)
+		return a
+	}
+	rs.tcpServer.Start()
+}
+
+func (rs *RemoteServer) Close() {
+	if rs.tcpServer != nil {
+		rs.tcpServer.Close()
+	}
+}
+
+func (a *remoteAgent) writeMsg(m *remoteMsg) {
+	err := a.conn.WriteMsg(m.marshal())
+	if err != nil {
+		log.ErrorF("write message error: %v", err)
+	}
+}
+
+func (a *remoteAgent) handshake() bool {
+	data, err := a.conn.ReadMsg()
+	if err != nil {
+		log.DebugF("read message: %v", err)
+		return false
+	}
+	m, err := unmarshalRemoteMsg(data)
+	if err != nil || m.t != remoteHandshake {
+		log.DebugF("invalid handshake from %v", a.conn.RemoteAddr())
+		return false
+	}
+
+	a.server = a.rs.servers[m.id]
+	if a.server == nil {
+		a.writeMsg(&remoteMsg{t: remoteHandshake, err: fmt.Sprintf("server %v: not published", m.id)})
+		return false
+	}
+
+	// functions
+	var functions []byte
+	for id, f := range a.server.functions {
+		if id, ok := id.(string); ok {
+			functions = append(functions, remoteType(f))
+			functions = binary.AppendUvarint(functions, uint64(len(id)))
+			functions = append(functions, id...)
+		}
+	}
+	a.writeMsg(&remoteMsg{t: remoteHandshake, data: functions})
+	return true
+}
+
+func (a *remoteAgent) Run() {
+	if !a.handshake() {
+		return
+	}
+
+	go func() {
+		for ri := range a.chanRet {
+			a.ret(ri)
+		}
+	}()
+
+	for {
+		data, err := a.conn.ReadMsg()
+		if err != nil {
+			log.DebugF("read message: %v", err)
+			break
+		}
+		m, err := unmarshalRemoteMsg(data)
+		if err != nil {
+			log.DebugF("unmarshal message error: %v", err)
+			break
+		}
+
+		switch m.t {
+		case remoteGo, remoteCall0, remoteCall1, remoteCallN:
+			a.exec(m)
+		default:
+			log.DebugF("invalid remote message type %v", m.t)
+		}
+	}
+}
+
+func (a *remoteAgent) exec(m *remoteMsg) {
+	ci := new(CallInfo)
+	ci.id = m.id
+	ci.f = a.server.functions[m.id]
+	if m.t != remoteGo {
+		ci.chanRet = a.chanRet
+		ci.cb = &remoteCall{t: m.t, seq: m.seq, id: m.id}
+	}
+
+	var err error
+	if ci.f == nil {
+		err = fmt.Errorf("function id %v: function not registered", m.id)
+	} else if m.t != remoteGo && remoteType(ci.f) != m.t {
+		err = fmt.Errorf("function id %v: return type mismatch", m.id)
+	} else if ci.args, err = a.rs.Codec.Unmarshal(m.data); err == nil {
+		err = send(a.server, ci)
+	}
+
+	if err != nil && ci.chanRet != nil {
+		a.server.ret(ci, &RetInfo{err: err})
+	}
+}
+
+func (a *remoteAgent) ret(ri *RetInfo) {
+	rc := ri.cb.(*remoteCall)
+	m := &remoteMsg{t: remoteRet, seq: rc.seq}
+	if ri.err != nil {
+		m.err = ri.err.Error()
+	} else {
+		var rets []interface{}
+		switch rc.t {
+		case remoteCall1:
+			rets = []interface{}{ri.ret}
+		case remoteCallN:
+			rets = assert(ri.ret)
+			// the codecs cannot encode the error of a typed function,
+			// it goes as its message
+			if _, ok := a.server.signatures[rc.id]; ok && len(rets) == 2 {
+				var msg interface{}
+				if err, ok := rets[1].(error); ok {
+					msg = err.Error()
+				}
+				rets = []interface{}{rets[0], msg}
+			}
+		}
+
+		var err error
+		m.data, err = a.rs.Codec.Marshal(rets)
+		if err != nil {
+			m.err = err.Error()
+		}
+	}
+
+	a.writeMsg(m)
+}
+
+func (a *remoteAgent) OnClose() {
+	close(a.chanRet)
+}
+
+func send(s *Server, ci *CallInfo) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = r.(error)
+		}
+	}()
+
+	s.ChanCall <- ci
+	return
+}
+
+// the local stand-in of a published server
+type remoteProxy struct {
+	s         *Server
+	codec     Codec
+	client    *network.TCPClient
+	conn      *network.TCPConn
+	name      string
+	chanReady chan error
+	seq       uint64
+	pending   map[uint64]*CallInfo
+	mutex     sync.Mutex
+	closeFlag bool
+}
+
+// goroutine safe
+//
+// Dial returns a server whose functions execute on the remote server
+// published under name, use Open or Attach to call them. Close the
+// returned server to disconnect
+func Dial(addr string, name string, codec Codec) (*Server, error) {
+	p := new(remoteProxy)
+	p.s = NewServer(100)
+	p.codec = codec
+	p.name = name
+	p.chanReady = make(chan error, 1)
+	p.pending = make(map[uint64]*CallInfo)
+
+	p.client = new(network.TCPClient)
+	p.client.Addr = addr
+	p.client.ConnNum = 1
+	p.client.TcpParser = newMsgParser()
+	p.client.NewAgent = func(conn *network.TCPConn) network.Agent {
+		p.conn = conn
+		return p
+	}
+	p.client.Start()
+
+	var err error
+	select {
+	case err = <-p.chanReady:
+	case <-time.After(remoteHandshakeTimeout):
+		err = fmt.Errorf("dial %v: handshake timeout", addr)
+	}
+	if err != nil {
+		p.client.Close()
+		return nil, err
+	}
+
+	go func() {
+		for ci := range p.s.ChanCall {
+			p.forward(ci)
+		}
+		p.client.Close()
+	}()
+
+	return p.s, nil
+}
+
+func (p *remoteProxy) handshake() error {
+	err := p.conn.WriteMsg((&remoteMsg{t: remoteHandshake, id: p.name}).marshal())
+	if err != nil {
+		return err
+	}
+
+	data, err := p.conn.ReadMsg()
+	if err != nil {
+		return err
+	}
+	m, err := unmarshalRemoteMsg(data)
+	if err != nil {
+		return err
+	}
+	if m.t != remoteHandshake {
+		return errors.New("invalid handshake")
+	}
+	if m.err != "" {
+		return errors.New(m.err)
+	}
+
+	// functions
+	b := m.data
+	for len(b) > 0 {
+		t := b[0]
+		var id string
+		id, b, err = readString(b[1:])
+		if err != nil {
+			return err
+		}
+
+		switch t {
+		case remoteCall0:
+			p.s.functions[id] = func([]interface{}) {}
+		case remoteCall1:
+			p.s.functions[id] = func([]interface{}) interface{} { return nil }
+		case remoteCallN:
+			p.s.functions[id] = func([]interface{}) []interface{} { return nil }
+		default:
+			return errors.New("invalid handshake")
+		}
+	}
+
+	return nil
+}
+
+func (p *remoteProxy) Run() {
+	err := p.handshake()
+	p.chanReady <- err
+	if err != nil {
+		return
+	}
+
+	for {
+		data, err := p.conn.ReadMsg()
+		if err != nil {
+			log.DebugF("read message: %v", err)
+			break
+		}
+		m, err := unmarshalRemoteMsg(data)
+		if err != nil || m.t != remoteRet {
+			log.DebugF("invalid remote message from %v", p.conn.RemoteAddr())
+			break
+		}
+
+		p.mutex.Lock()
+		ci := p.pending[m.seq]
+		delete(p.pending, m.seq)
+		p.mutex.Unlock()
+		if ci == nil {
+			continue
+		}
+
+		ri := new(RetInfo)
+		if m.err != "" {
+			ri.err = errors.New(m.err)
+		} else {
+			var rets []interface{}
+			rets, ri.err = p.codec.Unmarshal(m.data)
+			switch remoteType(ci.f) {
+			case remoteCall1:
+				if len(rets) > 0 {
+					ri.ret = rets[0]
+				}
+			case remoteCallN:
+				ri.ret = rets
+			}
+		}
+		p.s.ret(ci, ri)
+	}
+}
+
+func (p *remoteProxy) OnClose() {
+	p.mutex.Lock()
+	p.closeFlag = true
+	pending := p.pending
+	p.pending = nil
+	p.mutex.Unlock()
+
+	for _, ci := range pending {
+		p.s.ret(ci, &RetInfo{err: errors.New("chanrpc remote closed")})
+	}
+}
+
+func (p *remoteProxy) forward(ci *CallInfo) {
+	id, _ := ci.id.(string)
+	m := &remoteMsg{t: remoteGo, id: id}
+	if ci.chanRet != nil {
+		m.t = remoteType(ci.f)
+	}
+
+	var err error
+	m.data, err = p.codec.Marshal(ci.args)
+	if err != nil {
+		p.s.ret(ci, &RetInfo{err: err})
+		return
+	}
+
+	p.mutex.Lock()
+	if p.closeFlag {
+		p.mutex.Unlock()
+		p.s.ret(ci, &RetInfo{err: errors.New("chanrpc remote closed")})
+		return
+	}
+	if ci.chanRet != nil {
+		p.seq++
+		m.seq = p.seq
+		p.pending[m.seq] = ci
+	}
+	p.mutex.Unlock()
+
+	err = p.conn.WriteMsg(m.marshal())
+	if err != nil {
+		p.mutex.Lock()
+		delete(p.pending, m.seq)
+		p.mutex.Unlock()
+		p.s.ret(ci, &RetInfo{err: err})
+	}
+}
@@ -0,0 +1,100 @@
+package chanrpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+type signature struct {
+	req  reflect.Type
+	resp reflect.Type
+}
+
+// *Server or *Client
+type Caller interface {
+	CallContextN(ctx context.Context, id interface{}, args ...interface{}) ([]interface{}, error)
+	server() *Server
+}
+
+func (s *Server) server() *Server {
+	return s
+}
+
+func (c *Client) server() *Server {
+	return c.s
+}
+
+func typeOf[T any]() reflect.Type {
+	return reflect.TypeOf((*T)(nil)).Elem()
+}
+
+// Handle registers f as the function id of s, the call returns
+// []interface{}{resp, err} to the untyped callers. Remote callers get
+// the message of err instead, Call makes an error of it again
+//
+// you must call the function before calling Open and Go
+func Handle[Req, Resp any](s *Server, id interface{}, f func(Req) (Resp, error)) {
+	s.Register(id, func(args []interface{}) []interface{} {
+		if len(args) != 1 {
+			var resp Resp
+			return []interface{}{resp, fmt.Errorf("function id %v: want 1 argument, got %v", id, len(args))}
+		}
+		req, ok := args[0].(Req)
+		if !ok && args[0] != nil {
+			var resp Resp
+			return []interface{}{resp, fmt.Errorf("function id %v: want argument %v, got %T", id, typeOf[Req](), args[0])}
+		}
+		resp, err := f(req)
+		return []interface{}{resp, err}
+	})
+
+	s.signatures[id] = signature{req: typeOf[Req](), resp: typeOf[Resp]()}
+}
+
+// Call calls the function id registered by Handle
+func Call[Req, Resp any](c Caller, id interface{}, req Req) (Resp, error) {
+	return CallContext[Req, Resp](context.Background(), c, id, req)
+}
+
+// the call is abandoned when ctx is done
+func CallContext[Req, Resp any](ctx context.Context, c Caller, id interface{}, req Req) (Resp, error) {
+	var resp Resp
+
+	// functions of remote servers are checked by the other side
+	if s := c.server(); s != nil {
+		if sig, ok := s.signatures[id]; ok {
+			if sig.req != typeOf[Req]() || sig.resp != typeOf[Resp]() {
+				return resp, fmt.Errorf("function id %v: handler is func(%v) (%v, error), called as func(%v) (%v, error)",
+					id, sig.req, sig.resp, typeOf[Req](), typeOf[Resp]())
+			}
+		}
+	}
+
+	ret, err := c.CallContextN(ctx, id, req)
+	if err != nil {
+		return resp, err
+	}
+	if len(ret) != 2 {
+		return resp, fmt.Errorf("function id %v: not registered by Handle", id)
+	}
+	if ret[0] != nil {
+		var ok bool
+		resp, ok = ret[0].(Resp)
+		if !ok {
+			return resp, fmt.Errorf("function id %v: want result %v, got %T", id, typeOf[Resp](), ret[0])
+		}
+	}
+	switch e := ret[1].(type) {
+	case nil:
+	case error:
+		err = e
+	case string:
+		// the message of the error of a remote function
+		err = errors.New(e)
+	default:
+		err = fmt.Errorf("%v", e)
+	}
+	return resp, err
+}
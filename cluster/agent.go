@@ -0,0 +1,204 @@
+package cluster
+
+import (
+	"fmt"
+	"gitee.com/aarlin/leaflet/log"
+	"gitee.com/aarlin/leaflet/network"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type Agent struct {
+	node         *Node
+	conn         *network.TCPConn
+	nodeID       string
+	nodeType     string
+	load         int64
+	addr         string
+	outgoing     bool
+	state        int
+	lastSeen     int64
+	seq          uint64
+	pending      map[uint64]chan *message
+	mutexPending sync.Mutex
+	closeFlag    bool
+}
+
+func (n *Node) newAgent(conn *network.TCPConn) network.Agent {
+	a := new(Agent)
+	a.node = n
+	a.conn = conn
+	a.pending = make(map[uint64]chan *message)
+	atomic.StoreInt64(&a.lastSeen, time.Now().UnixNano())
+	return a
+}
+
+func (n *Node) newClientAgent(conn *network.TCPConn) network.Agent {
+	a := n.newAgent(conn).(*Agent)
+	a.outgoing = true
+	return a
+}
+
+func (a *Agent) NodeID() string {
+	return a.nodeID
+}
+
+func (a *Agent) writeMsg(m *message) error {
+	data, err := encodeMsg(m)
+	if err != nil {
+		return err
+	}
+	return a.conn.WriteMsg(data)
+}
+
+func (a *Agent) call(m *message) *message {
+	chanRet := make(chan *message, 1)
+
+	a.mutexPending.Lock()
+	if a.closeFlag {
+		a.mutexPending.Unlock()
+		return &message{Err: "node closed"}
+	}
+	a.seq++
+	m.Seq = a.seq
+	a.pending[m.Seq] = chanRet
+	a.mutexPending.Unlock()
+
+	err := a.writeMsg(m)
+	if err != nil {
+		a.mutexPending.Lock()
+		delete(a.pending, m.Seq)
+		a.mutexPending.Unlock()
+		return &message{Err: err.Error()}
+	}
+
+	return <-chanRet
+}
+
+func (a *Agent) Run() {
+	err := a.writeMsg(&message{
+		Type:     msgHandshake,
+		NodeID:   a.node.ID,
+		NodeType: a.node.Type,
+		Load:     a.node.Load(),
+		Addr:     a.node.AdvertiseAddr,
+		Members:  a.node.memberAddrs(),
+	})
+	if err != nil {
+		log.ErrorF("cluster handshake error: %v", err)
+		return
+	}
+
+	for {
+		data, err := a.conn.ReadMsg()
+		if err != nil {
+			log.DebugF("read message: %v", err)
+			break
+		}
+
+		m, err := decodeMsg(data)
+		if err != nil {
+			log.ErrorF("decode message error: %v", err)
+			break
+		}
+		atomic.StoreInt64(&a.lastSeen, time.Now().UnixNano())
+
+		if a.nodeID == "" && m.Type != msgHandshake {
+			log.ErrorF("cluster message before handshake from %v", a.conn.RemoteAddr())
+			break
+		}
+
+		switch m.Type {
+		case msgHandshake:
+			if m.NodeID == "" || m.NodeID == a.node.ID {
+				log.ErrorF("cluster handshake from %v: invalid node id %v", a.conn.RemoteAddr(), m.NodeID)
+				return
+			}
+			a.nodeID = m.NodeID
+			a.nodeType = m.NodeType
+			a.addr = m.Addr
+			atomic.StoreInt64(&a.load, int64(m.Load))
+			if !a.node.addAgent(a) {
+				log.DebugF("node %v: cluster closed", m.NodeID)
+				a.nodeID = ""
+				return
+			}
+			a.node.discover(m.Members)
+		case msgHeartbeat:
+			atomic.StoreInt64(&a.load, int64(m.Load))
+			a.node.discover(m.Members)
+		case msgGo:
+			a.handleGo(m)
+		case msgCall0, msgCall1, msgCallN:
+			go a.handleCall(m)
+		case msgRet:
+			a.mutexPending.Lock()
+			chanRet := a.pending[m.Seq]
+			delete(a.pending, m.Seq)
+			a.mutexPending.Unlock()
+			if chanRet != nil {
+				chanRet <- m
+			}
+		default:
+			log.ErrorF("invalid cluster message type %v", m.Type)
+		}
+	}
+}
+
+func (a *Agent) handleGo(m *message) {
+	s := a.node.servers[m.Server]
+	if s == nil {
+		log.ErrorF("chanrpc server %v: not registered", m.Server)
+		return
+	}
+
+	s.Go(m.ID, m.Args...)
+}
+
+func (a *Agent) handleCall(m *message) {
+	ret := &message{Type: msgRet, Seq: m.Seq}
+
+	var err error
+	s := a.node.servers[m.Server]
+	if s == nil {
+		err = fmt.Errorf("chanrpc server %v: not registered", m.Server)
+	} else {
+		switch m.Type {
+		case msgCall0:
+			err = s.Call0(m.ID, m.Args...)
+		case msgCall1:
+			ret.Ret, err = s.Call1(m.ID, m.Args...)
+		case msgCallN:
+			var rets []interface{}
+			rets, err = s.CallN(m.ID, m.Args...)
+			if rets != nil {
+				ret.Ret = rets
+			}
+		}
+	}
+	if err != nil {
+		ret.Err = err.Error()
+	}
+
+	err = a.writeMsg(ret)
+	if err != nil {
+		log.ErrorF("write message error: %v", err)
+	}
+}
+
+func (a *Agent) OnClose() {
+	if a.nodeID != "" {
+		a.node.removeAgent(a)
+	}
+
+	a.mutexPending.Lock()
+	a.closeFlag = true
+	pending := a.pending
+	a.pending = nil
+	a.mutexPending.Unlock()
+
+	for _, chanRet := range pending {
+		chanRet <- &message{Err: "node closed"}
+	}
+}
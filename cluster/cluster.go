@@ -1,69 +1,317 @@
 package cluster
 
 import (
+	"errors"
+	"fmt"
+	"gitee.com/aarlin/leaflet/chanrpc"
 	"gitee.com/aarlin/leaflet/conf"
+	"gitee.com/aarlin/leaflet/log"
 	"gitee.com/aarlin/leaflet/network"
 	"math"
+	"sync"
 	"time"
 )
 
-var (
-	server  *network.TCPServer
-	clients []*network.TCPClient
-)
+type Node struct {
+	ID                string
+	Type              string
+	ListenAddr        string
+	AdvertiseAddr     string
+	ConnAddrs         []string
+	PendingWriteNum   int
+	HeartbeatInterval time.Duration
+	SuspectTimeout    time.Duration
+	DeadTimeout       time.Duration
+
+	// NodeUp and NodeDown are sent to EventChanRPC with the node id
+	EventChanRPC *chanrpc.Server
+
+	server      *network.TCPServer
+	clients     []*network.TCPClient
+	servers     map[string]*chanrpc.Server
+	agents      map[string]*Agent
+	conns       map[string][]*Agent
+	peers       map[string]*peer
+	routes      map[string][]string
+	policies    map[string]Policy
+	load        int64
+	mutexAgents sync.Mutex
+	closeFlag   bool
+	closeSig    chan bool
+	wg          sync.WaitGroup
+}
+
+var node = NewNode()
+
+func NewNode() *Node {
+	n := new(Node)
+	n.servers = make(map[string]*chanrpc.Server)
+	n.agents = make(map[string]*Agent)
+	n.conns = make(map[string][]*Agent)
+	n.peers = make(map[string]*peer)
+	n.routes = make(map[string][]string)
+	n.policies = make(map[string]Policy)
+	return n
+}
 
 func Init() {
-	if conf.ListenAddr != "" {
-		server = new(network.TCPServer)
-		server.Addr = conf.ListenAddr
-		server.MaxConnNum = int(math.MaxInt32)
-		server.PendingWriteNum = conf.PendingWriteNum
-		//server.LenMsgLen = 4
-		//server.MaxMsgLen = math.MaxUint32
-		server.NewAgent = newAgent
-		server.TcpParser = network.NewMsgParser()
-		server.TcpParser.SetMsgLen(4,1,math.MaxUint32)
-
-		server.Start()
-	}
-
-	for _, addr := range conf.ConnAddrs {
-		client := new(network.TCPClient)
-		client.Addr = addr
-		client.ConnNum = 1
-		client.ConnectInterval = 3 * time.Second
-		client.PendingWriteNum = conf.PendingWriteNum
-		//client.LenMsgLen = 4
-		//client.MaxMsgLen = math.MaxUint32
-		client.NewAgent = newAgent
-		client.TcpParser = network.NewMsgParser()
-		client.TcpParser.SetMsgLen(4,1,math.MaxUint32)
+	node.ID = conf.NodeID
+	node.Type = conf.NodeType
+	node.ListenAddr = conf.ListenAddr
+	node.AdvertiseAddr = conf.AdvertiseAddr
+	node.ConnAddrs = conf.ConnAddrs
+	node.PendingWriteNum = conf.PendingWriteNum
+	node.HeartbeatInterval = conf.HeartbeatInterval
+	node.SuspectTimeout = conf.SuspectTimeout
+	node.DeadTimeout = conf.DeadTimeout
+	node.Start()
+}
+
+func Destroy() {
+	node.Close()
+}
+
+// you must call the function before calling Init
+func Register(name string, server *chanrpc.Server) {
+	node.Register(name, server)
+}
+
+// goroutine safe
+func Nodes() []string {
+	return node.Nodes()
+}
+
+// goroutine safe
+func Members() []Member {
+	return node.Members()
+}
+
+// you must call the function before calling Init
+func SetPolicy(nodeType string, policy Policy) {
+	node.SetPolicy(nodeType, policy)
+}
+
+// goroutine safe
+func SetLoad(load int) {
+	node.SetLoad(load)
+}
+
+// goroutine safe
+func GoAny(nodeType string, key string, server string, id string, args ...interface{}) error {
+	return node.GoAny(nodeType, key, server, id, args...)
+}
+
+// goroutine safe
+func CallAny0(nodeType string, key string, server string, id string, args ...interface{}) error {
+	return node.CallAny0(nodeType, key, server, id, args...)
+}
+
+// goroutine safe
+func CallAny1(nodeType string, key string, server string, id string, args ...interface{}) (interface{}, error) {
+	return node.CallAny1(nodeType, key, server, id, args...)
+}
+
+// goroutine safe
+func CallAnyN(nodeType string, key string, server string, id string, args ...interface{}) ([]interface{}, error) {
+	return node.CallAnyN(nodeType, key, server, id, args...)
+}
+
+// goroutine safe
+func Broadcast(nodeType string, server string, id string, args ...interface{}) {
+	node.Broadcast(nodeType, server, id, args...)
+}
+
+// goroutine safe
+func Go(nodeID string, server string, id string, args ...interface{}) error {
+	return node.Go(nodeID, server, id, args...)
+}
+
+// goroutine safe
+func Call0(nodeID string, server string, id string, args ...interface{}) error {
+	return node.Call0(nodeID, server, id, args...)
+}
+
+// goroutine safe
+func Call1(nodeID string, server string, id string, args ...interface{}) (interface{}, error) {
+	return node.Call1(nodeID, server, id, args...)
+}
+
+// goroutine safe
+func CallN(nodeID string, server string, id string, args ...interface{}) ([]interface{}, error) {
+	return node.CallN(nodeID, server, id, args...)
+}
+
+// you must call the function before calling Start
+func (n *Node) Register(name string, server *chanrpc.Server) {
+	if _, ok := n.servers[name]; ok {
+		panic(fmt.Sprintf("chanrpc server %v: already registered", name))
+	}
 
+	n.servers[name] = server
+}
+
+func (n *Node) init() {
+	if n.ID == "" {
+		log.FatalF("cluster node id must not be empty")
+	}
+	if n.AdvertiseAddr == "" {
+		n.AdvertiseAddr = n.ListenAddr
+	}
+	if n.HeartbeatInterval <= 0 {
+		n.HeartbeatInterval = time.Second
+		log.ReleaseF("invalid HeartbeatInterval, reset to %v", n.HeartbeatInterval)
+	}
+	if n.SuspectTimeout <= 0 {
+		n.SuspectTimeout = 3 * n.HeartbeatInterval
+		log.ReleaseF("invalid SuspectTimeout, reset to %v", n.SuspectTimeout)
+	}
+	if n.DeadTimeout <= n.SuspectTimeout {
+		n.DeadTimeout = 2 * n.SuspectTimeout
+		log.ReleaseF("invalid DeadTimeout, reset to %v", n.DeadTimeout)
+	}
+	n.closeSig = make(chan bool)
+}
+
+func (n *Node) Start() {
+	if n.ListenAddr == "" && len(n.ConnAddrs) == 0 {
+		return
+	}
+	n.init()
+
+	if n.ListenAddr != "" {
+		n.server = new(network.TCPServer)
+		n.server.Addr = n.ListenAddr
+		n.server.MaxConnNum = int(math.MaxInt32)
+		n.server.PendingWriteNum = n.PendingWriteNum
+		n.server.NewAgent = n.newAgent
+		n.server.TcpParser = network.NewMsgParser()
+		n.server.TcpParser.SetMsgLen(4, 1, math.MaxUint32)
+
+		n.server.Start()
+	}
+
+	for _, addr := range n.ConnAddrs {
+		n.clients = append(n.clients, n.newClient(addr))
+	}
+	for _, client := range n.clients {
 		client.Start()
-		clients = append(clients, client)
 	}
+
+	n.wg.Add(1)
+	go n.heartbeat()
 }
 
-func Destroy() {
-	if server != nil {
-		server.Close()
+func (n *Node) newClient(addr string) *network.TCPClient {
+	client := new(network.TCPClient)
+	client.Addr = addr
+	client.ConnNum = 1
+	client.ConnectInterval = 3 * time.Second
+	client.PendingWriteNum = n.PendingWriteNum
+	client.AutoReconnect = true
+	client.NewAgent = n.newClientAgent
+	client.TcpParser = network.NewMsgParser()
+	client.TcpParser.SetMsgLen(4, 1, math.MaxUint32)
+	return client
+}
+
+func (n *Node) Close() {
+	if n.closeSig == nil {
+		return
+	}
+	close(n.closeSig)
+	n.wg.Wait()
+
+	if n.server != nil {
+		n.server.Close()
 	}
 
-	for _, client := range clients {
+	for _, client := range n.clients {
 		client.Close()
 	}
+	n.clients = nil
+
+	n.mutexAgents.Lock()
+	n.closeFlag = true
+	peers := n.peers
+	n.peers = make(map[string]*peer)
+	n.mutexAgents.Unlock()
+	for _, p := range peers {
+		p.client.Close()
+	}
+}
+
+// goroutine safe
+func (n *Node) Nodes() []string {
+	n.mutexAgents.Lock()
+	defer n.mutexAgents.Unlock()
+
+	ids := make([]string, 0, len(n.agents))
+	for id := range n.agents {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func (n *Node) agent(nodeID string) (*Agent, error) {
+	n.mutexAgents.Lock()
+	defer n.mutexAgents.Unlock()
+
+	a := n.agents[nodeID]
+	if a == nil {
+		return nil, fmt.Errorf("node %v: not connected", nodeID)
+	}
+	return a, nil
 }
 
-type Agent struct {
-	conn *network.TCPConn
+// goroutine safe
+func (n *Node) Go(nodeID string, server string, id string, args ...interface{}) error {
+	a, err := n.agent(nodeID)
+	if err != nil {
+		return err
+	}
+
+	return a.writeMsg(&message{
+		Type:   msgGo,
+		Server: server,
+		ID:     id,
+		Args:   args,
+	})
+}
+
+// goroutine safe
+func (n *Node) Call0(nodeID string, server string, id string, args ...interface{}) error {
+	_, err := n.call(nodeID, msgCall0, server, id, args)
+	return err
+}
+
+// goroutine safe
+func (n *Node) Call1(nodeID string, server string, id string, args ...interface{}) (interface{}, error) {
+	return n.call(nodeID, msgCall1, server, id, args)
 }
 
-func newAgent(conn *network.TCPConn) network.Agent {
-	a := new(Agent)
-	a.conn = conn
-	return a
+// goroutine safe
+func (n *Node) CallN(nodeID string, server string, id string, args ...interface{}) ([]interface{}, error) {
+	ret, err := n.call(nodeID, msgCallN, server, id, args)
+	if ret == nil {
+		return nil, err
+	}
+	return ret.([]interface{}), err
 }
 
-func (a *Agent) Run() {}
+func (n *Node) call(nodeID string, t int, server string, id string, args []interface{}) (interface{}, error) {
+	a, err := n.agent(nodeID)
+	if err != nil {
+		return nil, err
+	}
 
-func (a *Agent) OnClose() {}
+	ret := a.call(&message{
+		Type:   t,
+		Server: server,
+		ID:     id,
+		Args:   args,
+	})
+	if ret.Err != "" {
+		return ret.Ret, errors.New(ret.Err)
+	}
+	return ret.Ret, nil
+}
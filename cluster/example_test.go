@@ -0,0 +1,175 @@
+package cluster_test
+
+import (
+	"fmt"
+	"gitee.com/aarlin/leaflet/chanrpc"
+	"gitee.com/aarlin/leaflet/cluster"
+	"time"
+)
+
+func waitNodes(n *cluster.Node, num int) {
+	for len(n.Nodes()) < num {
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func Example() {
+	s := chanrpc.NewServer(10)
+	s.Register("add", func(args []interface{}) interface{} {
+		return args[0].(int) + args[1].(int)
+	})
+	go func() {
+		for ci := range s.ChanCall {
+			s.Exec(ci)
+		}
+	}()
+
+	// node 1
+	n1 := cluster.NewNode()
+	n1.ID = "game1"
+	n1.ListenAddr = "127.0.0.1:37101"
+	n1.Register("game", s)
+	n1.Start()
+	defer n1.Close()
+
+	// node 2
+	n2 := cluster.NewNode()
+	n2.ID = "gate1"
+	n2.ConnAddrs = []string{"127.0.0.1:37101"}
+	n2.Start()
+	defer n2.Close()
+
+	waitNodes(n2, 1)
+
+	ret, err := n2.Call1("game1", "game", "add", 1, 2)
+	fmt.Println(ret, err)
+
+	_, err = n2.Call1("game1", "db", "add", 1, 2)
+	fmt.Println(err)
+
+	_, err = n2.Call1("db1", "db", "add", 1, 2)
+	fmt.Println(err)
+
+	// Output:
+	// 3 <nil>
+	// chanrpc server db: not registered
+	// node db1: not connected
+}
+
+func ExampleNode_Members() {
+	events := chanrpc.NewServer(10)
+	events.Register("NodeUp", func(args []interface{}) {
+		fmt.Println("up", args[0])
+	})
+	events.Register("NodeDown", func(args []interface{}) {
+		fmt.Println("down", args[0])
+	})
+
+	newNode := func(id string, addr string, seeds ...string) *cluster.Node {
+		n := cluster.NewNode()
+		n.ID = id
+		n.ListenAddr = addr
+		n.ConnAddrs = seeds
+		n.HeartbeatInterval = 20 * time.Millisecond
+		return n
+	}
+
+	// seed
+	seed := newNode("a", "127.0.0.1:37111")
+	seed.Start()
+	defer seed.Close()
+
+	// c only knows the seed
+	c := newNode("c", "127.0.0.1:37113", "127.0.0.1:37111")
+	c.EventChanRPC = events
+	c.Start()
+
+	waitNodes(c, 1)
+	events.Exec(<-events.ChanCall)
+
+	// b and c find each other through the seed
+	b := newNode("b", "127.0.0.1:37112", "127.0.0.1:37111")
+	b.Start()
+
+	waitNodes(c, 2)
+	events.Exec(<-events.ChanCall)
+	for _, m := range c.Members() {
+		if m.ID == "b" {
+			fmt.Println(m.Addr, m.State == cluster.MemberAlive)
+		}
+	}
+
+	b.Close()
+	events.Exec(<-events.ChanCall)
+	c.Close()
+
+	// Output:
+	// up a
+	// up b
+	// 127.0.0.1:37112 true
+	// down b
+}
+
+func ExampleNode_Select() {
+	newGame := func(id string, addr string) *cluster.Node {
+		s := chanrpc.NewServer(10)
+		s.Register("whoami", func(args []interface{}) interface{} {
+			return id
+		})
+		go func() {
+			for ci := range s.ChanCall {
+				s.Exec(ci)
+			}
+		}()
+
+		n := cluster.NewNode()
+		n.ID = id
+		n.Type = "game"
+		n.ListenAddr = addr
+		n.Register("game", s)
+		n.Start()
+		return n
+	}
+
+	game1 := newGame("game1", "127.0.0.1:37121")
+	defer game1.Close()
+	game2 := newGame("game2", "127.0.0.1:37122")
+	defer game2.Close()
+
+	gate := cluster.NewNode()
+	gate.ID = "gate1"
+	gate.Type = "gate"
+	gate.ConnAddrs = []string{"127.0.0.1:37121", "127.0.0.1:37122"}
+	gate.SetPolicy("game", new(cluster.ConsistentHash))
+	gate.Start()
+	defer gate.Close()
+
+	waitNodes(gate, 2)
+
+	// the same key goes to the same node
+	r1, _ := gate.CallAny1("game", "player:42", "game", "whoami")
+	r2, _ := gate.CallAny1("game", "player:42", "game", "whoami")
+	fmt.Println(r1 == r2)
+
+	_, err := gate.CallAny1("db", "", "db", "whoami")
+	fmt.Println(err)
+
+	// Output:
+	// true
+	// node type db: no node available
+}
+
+func ExampleRoundRobin() {
+	members := []cluster.Member{{ID: "game1"}, {ID: "game2"}, {ID: "game3"}}
+
+	p := new(cluster.RoundRobin)
+	for i := 0; i < 4; i++ {
+		fmt.Println(members[p.Select(members, "")].ID)
+	}
+
+	// Output:
+	// game1
+	// game2
+	// game3
+	// game1
+}
@@ -0,0 +1,262 @@
+package cluster
+
+import (
+	"gitee.com/aarlin/leaflet/log"
+	"gitee.com/aarlin/leaflet/network"
+	"sync/atomic"
+	"time"
+)
+
+// member states
+const (
+	MemberAlive = iota
+	MemberSuspect
+	MemberDead
+)
+
+type Member struct {
+	ID       string
+	Type     string
+	Addr     string
+	Load     int
+	State    int
+	LastSeen time.Time
+}
+
+// a node learned from the member list of another node
+type peer struct {
+	client   *network.TCPClient
+	lastSeen time.Time
+}
+
+func (n *Node) event(id string, nodeID string) {
+	if n.EventChanRPC != nil {
+		n.EventChanRPC.Go(id, nodeID)
+	}
+}
+
+// the connection dialed by the node with the smaller id is preferred,
+// so that both ends pick the same one when two nodes dial each other
+func (n *Node) preferred(a *Agent) bool {
+	return a.outgoing == (n.ID < a.nodeID)
+}
+
+func (n *Node) addAgent(a *Agent) bool {
+	n.mutexAgents.Lock()
+	if n.closeFlag {
+		n.mutexAgents.Unlock()
+		return false
+	}
+
+	n.conns[a.nodeID] = append(n.conns[a.nodeID], a)
+
+	primary := n.agents[a.nodeID]
+	if primary != nil {
+		if !n.preferred(primary) && n.preferred(a) {
+			n.agents[a.nodeID] = a
+		}
+		n.mutexAgents.Unlock()
+		return true
+	}
+	n.agents[a.nodeID] = a
+	n.updateRoutes(a.nodeType)
+	n.mutexAgents.Unlock()
+
+	log.ReleaseF("node %v up (%v)", a.nodeID, a.conn.RemoteAddr())
+	n.event("NodeUp", a.nodeID)
+	return true
+}
+
+func (n *Node) removeAgent(a *Agent) {
+	if n.doRemoveAgent(a) {
+		log.ReleaseF("node %v down", a.nodeID)
+		n.event("NodeDown", a.nodeID)
+	}
+}
+
+func (n *Node) doRemoveAgent(a *Agent) bool {
+	n.mutexAgents.Lock()
+	defer n.mutexAgents.Unlock()
+
+	conns := n.conns[a.nodeID]
+	for i := range conns {
+		if conns[i] == a {
+			conns = append(conns[:i], conns[i+1:]...)
+			break
+		}
+	}
+	if len(conns) > 0 {
+		n.conns[a.nodeID] = conns
+	} else {
+		delete(n.conns, a.nodeID)
+	}
+
+	if n.agents[a.nodeID] != a {
+		return false
+	}
+	if len(conns) == 0 {
+		delete(n.agents, a.nodeID)
+		n.updateRoutes(a.nodeType)
+		return true
+	}
+
+	primary := conns[0]
+	for _, c := range conns {
+		if n.preferred(c) {
+			primary = c
+			break
+		}
+	}
+	n.agents[a.nodeID] = primary
+	return false
+}
+
+// goroutine safe
+func (n *Node) Members() []Member {
+	n.mutexAgents.Lock()
+	defer n.mutexAgents.Unlock()
+
+	members := make([]Member, 0, len(n.agents))
+	for _, a := range n.agents {
+		members = append(members, a.member())
+	}
+	return members
+}
+
+// the caller must hold mutexAgents
+func (a *Agent) member() Member {
+	return Member{
+		ID:       a.nodeID,
+		Type:     a.nodeType,
+		Addr:     a.addr,
+		Load:     int(atomic.LoadInt64(&a.load)),
+		State:    a.state,
+		LastSeen: time.Unix(0, atomic.LoadInt64(&a.lastSeen)),
+	}
+}
+
+func (n *Node) memberAddrs() map[string]string {
+	n.mutexAgents.Lock()
+	defer n.mutexAgents.Unlock()
+
+	addrs := make(map[string]string, len(n.agents))
+	for id, a := range n.agents {
+		if a.addr != "" {
+			addrs[id] = a.addr
+		}
+	}
+	return addrs
+}
+
+func (n *Node) isSeed(addr string) bool {
+	for _, seed := range n.ConnAddrs {
+		if seed == addr {
+			return true
+		}
+	}
+	return false
+}
+
+// dials the members not connected yet, the node with the smaller id dials
+func (n *Node) discover(members map[string]string) {
+	now := time.Now()
+
+	n.mutexAgents.Lock()
+	defer n.mutexAgents.Unlock()
+
+	if n.closeFlag {
+		return
+	}
+
+	for id, addr := range members {
+		if id == n.ID || addr == "" {
+			continue
+		}
+		if p := n.peers[id]; p != nil {
+			p.lastSeen = now
+			continue
+		}
+		if n.ID > id || len(n.conns[id]) > 0 || n.isSeed(addr) {
+			continue
+		}
+
+		log.ReleaseF("node %v discovered (%v)", id, addr)
+		p := &peer{client: n.newClient(addr), lastSeen: now}
+		n.peers[id] = p
+		p.client.Start()
+	}
+}
+
+func (n *Node) heartbeat() {
+	defer n.wg.Done()
+
+	ticker := time.NewTicker(n.HeartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-n.closeSig:
+			return
+		case now := <-ticker.C:
+			n.check(now)
+		}
+	}
+}
+
+func (n *Node) check(now time.Time) {
+	data, err := encodeMsg(&message{
+		Type:    msgHeartbeat,
+		Load:    n.Load(),
+		Members: n.memberAddrs(),
+	})
+	if err != nil {
+		log.ErrorF("encode message error: %v", err)
+		return
+	}
+
+	var alive, dead []*Agent
+
+	n.mutexAgents.Lock()
+	for _, conns := range n.conns {
+		for _, a := range conns {
+			elapsed := now.Sub(time.Unix(0, atomic.LoadInt64(&a.lastSeen)))
+			switch {
+			case elapsed > n.DeadTimeout:
+				if a.state != MemberDead {
+					a.state = MemberDead
+					log.ReleaseF("node %v dead: no heartbeat for %v", a.nodeID, elapsed)
+					dead = append(dead, a)
+				}
+				continue
+			case elapsed > n.SuspectTimeout:
+				if a.state == MemberAlive {
+					a.state = MemberSuspect
+					log.ReleaseF("node %v suspect: no heartbeat for %v", a.nodeID, elapsed)
+				}
+			default:
+				if a.state == MemberSuspect {
+					a.state = MemberAlive
+					log.ReleaseF("node %v alive again", a.nodeID)
+				}
+			}
+			alive = append(alive, a)
+		}
+	}
+
+	for id, p := range n.peers {
+		if len(n.conns[id]) > 0 {
+			p.lastSeen = now
+		} else if now.Sub(p.lastSeen) > n.DeadTimeout {
+			delete(n.peers, id)
+			go p.client.Close()
+		}
+	}
+	n.mutexAgents.Unlock()
+
+	for _, a := range dead {
+		a.conn.Destroy()
+	}
+	for _, a := range alive {
+		a.conn.WriteMsg(data)
+	}
+}
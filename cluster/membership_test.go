@@ -0,0 +1,121 @@
+package cluster
+
+import (
+	"encoding/binary"
+	"gitee.com/aarlin/leaflet/chanrpc"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCheckStalledPeer(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	events := chanrpc.NewServer(10)
+	received := make(chan [2]string, 1)
+	for _, id := range []string{"NodeUp", "NodeDown"} {
+		id := id
+		events.Register(id, func(args []interface{}) {
+			received <- [2]string{id, args[0].(string)}
+		})
+	}
+	n := NewNode()
+	n.ID = "a"
+	n.ListenAddr = addr
+	n.EventChanRPC = events
+	// the test runs the checks
+	n.HeartbeatInterval = time.Hour
+	n.SuspectTimeout = time.Minute
+	n.DeadTimeout = 2 * time.Minute
+	n.Start()
+	defer n.Close()
+
+	nextEvent := func() (string, string) {
+		select {
+		case ci := <-events.ChanCall:
+			events.Exec(ci)
+			e := <-received
+			return e[0], e[1]
+		case <-time.After(5 * time.Second):
+			t.Fatal("no event")
+			return "", ""
+		}
+	}
+
+	// the peer says hello then stalls, its connection stays open
+	var conn net.Conn
+	for i := 0; i < 100; i++ {
+		if conn, err = net.Dial("tcp", addr); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	data, err := encodeMsg(&message{Type: msgHandshake, NodeID: "b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	frame := make([]byte, 4+len(data))
+	binary.BigEndian.PutUint32(frame, uint32(len(data)))
+	copy(frame[4:], data)
+	if _, err := conn.Write(frame); err != nil {
+		t.Fatal(err)
+	}
+	if id, nodeID := nextEvent(); id != "NodeUp" || nodeID != "b" {
+		t.Fatalf("event %v %v, want NodeUp b", id, nodeID)
+	}
+
+	n.mutexAgents.Lock()
+	a := n.agents["b"]
+	n.mutexAgents.Unlock()
+	seen := time.Unix(0, atomic.LoadInt64(&a.lastSeen))
+	state := func() int {
+		n.mutexAgents.Lock()
+		defer n.mutexAgents.Unlock()
+		return a.state
+	}
+
+	n.check(seen.Add(n.SuspectTimeout / 2))
+	if s := state(); s != MemberAlive {
+		t.Fatalf("state %v, want alive", s)
+	}
+	n.check(seen.Add(n.SuspectTimeout + time.Second))
+	if s := state(); s != MemberSuspect {
+		t.Fatalf("state %v, want suspect", s)
+	}
+	if members := n.Members(); len(members) != 1 || members[0].State != MemberSuspect {
+		t.Fatalf("members %+v, want b suspect", members)
+	}
+
+	n.check(seen.Add(n.DeadTimeout + time.Second))
+	if s := state(); s != MemberDead {
+		t.Fatalf("state %v, want dead", s)
+	}
+	if id, nodeID := nextEvent(); id != "NodeDown" || nodeID != "b" {
+		t.Fatalf("event %v %v, want NodeDown b", id, nodeID)
+	}
+	if nodes := n.Nodes(); len(nodes) != 0 {
+		t.Fatalf("nodes %v after NodeDown", nodes)
+	}
+
+	// the connection of the dead peer is closed by the node
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 4096)
+	for {
+		if _, err := conn.Read(buf); err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				t.Fatal("connection of the dead peer open")
+			}
+			break
+		}
+	}
+}
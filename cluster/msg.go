@@ -0,0 +1,58 @@
+package cluster
+
+import (
+	"bytes"
+	"encoding/gob"
+)
+
+// message types
+const (
+	msgHandshake = iota
+	msgGo
+	msgCall0
+	msgCall1
+	msgCallN
+	msgRet
+	msgHeartbeat
+)
+
+// every frame sent between nodes, gob encoded
+//
+// the concrete types of Args and Ret must be registered
+// with gob.Register on both nodes
+type message struct {
+	Type     int
+	NodeID   string
+	NodeType string
+	Load     int
+	Addr     string
+	Members  map[string]string
+	Seq      uint64
+	Server   string
+	ID       string
+	Args     []interface{}
+	Ret      interface{}
+	Err      string
+}
+
+func init() {
+	gob.Register([]interface{}{})
+}
+
+func encodeMsg(m *message) ([]byte, error) {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(m)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func decodeMsg(data []byte) (*message, error) {
+	m := new(message)
+	err := gob.NewDecoder(bytes.NewReader(data)).Decode(m)
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
@@ -0,0 +1,171 @@
+package cluster
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"sync/atomic"
+)
+
+// Policy picks one of the nodes of a type
+type Policy interface {
+	// must goroutine safe
+	// members is never empty and sorted by id
+	Select(members []Member, key string) int
+}
+
+// round-robin
+type RoundRobin struct {
+	next uint64
+}
+
+func (p *RoundRobin) Select(members []Member, key string) int {
+	return int((atomic.AddUint64(&p.next, 1) - 1) % uint64(len(members)))
+}
+
+// the node with the lowest load reported by SetLoad
+type LeastLoaded struct{}
+
+func (p *LeastLoaded) Select(members []Member, key string) int {
+	i := 0
+	for j := 1; j < len(members); j++ {
+		if members[j].Load < members[i].Load {
+			i = j
+		}
+	}
+	return i
+}
+
+// rendezvous hashing, a key stays on the same node
+// unless that node leaves
+type ConsistentHash struct{}
+
+func (p *ConsistentHash) Select(members []Member, key string) int {
+	var i int
+	var max uint64
+	for j, m := range members {
+		h := fnv.New64a()
+		h.Write([]byte(key))
+		h.Write([]byte{0})
+		h.Write([]byte(m.ID))
+		if w := h.Sum64(); j == 0 || w > max {
+			i = j
+			max = w
+		}
+	}
+	return i
+}
+
+// you must call the function before calling Start
+func (n *Node) SetPolicy(nodeType string, policy Policy) {
+	n.policies[nodeType] = policy
+}
+
+// goroutine safe
+//
+// the load is sent to the other nodes with the next heartbeat
+func (n *Node) SetLoad(load int) {
+	atomic.StoreInt64(&n.load, int64(load))
+}
+
+// goroutine safe
+func (n *Node) Load() int {
+	return int(atomic.LoadInt64(&n.load))
+}
+
+// the caller must hold mutexAgents
+func (n *Node) updateRoutes(nodeType string) {
+	var ids []string
+	for id, a := range n.agents {
+		if a.nodeType == nodeType {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+
+	if len(ids) > 0 {
+		n.routes[nodeType] = ids
+	} else {
+		delete(n.routes, nodeType)
+	}
+}
+
+func (n *Node) policy(nodeType string) Policy {
+	p := n.policies[nodeType]
+	if p == nil {
+		p = n.policies[""]
+	}
+	if p == nil {
+		p = new(RoundRobin)
+		n.policies[""] = p
+	}
+	return p
+}
+
+// goroutine safe
+//
+// Select returns the id of a node of the type chosen by its policy
+func (n *Node) Select(nodeType string, key string) (string, error) {
+	n.mutexAgents.Lock()
+	ids := n.routes[nodeType]
+	members := make([]Member, len(ids))
+	for i, id := range ids {
+		members[i] = n.agents[id].member()
+	}
+	p := n.policy(nodeType)
+	n.mutexAgents.Unlock()
+
+	if len(members) == 0 {
+		return "", fmt.Errorf("node type %v: no node available", nodeType)
+	}
+	return members[p.Select(members, key)].ID, nil
+}
+
+// goroutine safe
+func (n *Node) GoAny(nodeType string, key string, server string, id string, args ...interface{}) error {
+	nodeID, err := n.Select(nodeType, key)
+	if err != nil {
+		return err
+	}
+	return n.Go(nodeID, server, id, args...)
+}
+
+// goroutine safe
+func (n *Node) CallAny0(nodeType string, key string, server string, id string, args ...interface{}) error {
+	nodeID, err := n.Select(nodeType, key)
+	if err != nil {
+		return err
+	}
+	return n.Call0(nodeID, server, id, args...)
+}
+
+// goroutine safe
+func (n *Node) CallAny1(nodeType string, key string, server string, id string, args ...interface{}) (interface{}, error) {
+	nodeID, err := n.Select(nodeType, key)
+	if err != nil {
+		return nil, err
+	}
+	return n.Call1(nodeID, server, id, args...)
+}
+
+// goroutine safe
+func (n *Node) CallAnyN(nodeType string, key string, server string, id string, args ...interface{}) ([]interface{}, error) {
+	nodeID, err := n.Select(nodeType, key)
+	if err != nil {
+		return nil, err
+	}
+	return n.CallN(nodeID, server, id, args...)
+}
+
+// goroutine safe
+//
+// Broadcast sends to all the nodes of the type
+func (n *Node) Broadcast(nodeType string, server string, id string, args ...interface{}) {
+	n.mutexAgents.Lock()
+	ids := n.routes[nodeType]
+	n.mutexAgents.Unlock()
+
+	for _, nodeID := range ids {
+		n.Go(nodeID, server, id, args...)
+	}
+}
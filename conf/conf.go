@@ -1,5 +1,7 @@
 package conf
 
+import "time"
+
 var (
 	LenStackBuf = 4096
 
@@ -10,13 +12,29 @@ var (
 	LogNamePrefix string
 	LogKeepHour int
 
+	// shutdown, each phase is abandoned after its timeout; reloaded, read
+	// them with ShutdownTimeouts
+	StopAcceptTimeout = 5 * time.Second
+	NotifyTimeout     = 5 * time.Second
+	DrainTimeout      = 30 * time.Second
+	DestroyTimeout    = 30 * time.Second
+
 	// console
 	ConsolePort   int
 	ConsolePrompt string = "Leaf# "
 	ProfilePath   string
 
+	// metrics, served over http if set, e.g. "localhost:9100"
+	MetricsAddr string
+
 	// cluster
-	ListenAddr      string
-	ConnAddrs       []string
-	PendingWriteNum int
+	NodeID            string
+	NodeType          string
+	ListenAddr        string
+	AdvertiseAddr     string
+	ConnAddrs         []string
+	PendingWriteNum   int
+	HeartbeatInterval time.Duration
+	SuspectTimeout    time.Duration
+	DeadTimeout       time.Duration
 )
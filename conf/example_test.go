@@ -0,0 +1,63 @@
+package conf_test
+
+import (
+	"errors"
+	"fmt"
+	"gitee.com/aarlin/leaflet/conf"
+	"io/ioutil"
+	"os"
+	"path"
+)
+
+type GameConf struct {
+	MaxPlayers int
+	Maps       []string
+}
+
+func (c *GameConf) Validate() error {
+	if c.MaxPlayers <= 0 {
+		return errors.New("MaxPlayers must be positive")
+	}
+	return nil
+}
+
+func ExampleLoad() {
+	dir, err := ioutil.TempDir("", "leaf")
+	if err != nil {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	jsonFile := path.Join(dir, "server.json")
+	ioutil.WriteFile(jsonFile, []byte(`{
+		"leaf": {"ConsolePort": 3333, "HeartbeatInterval": "2s"},
+		"game": {"MaxPlayers": 100}
+	}`), 0644)
+	yamlFile := path.Join(dir, "local.yaml")
+	ioutil.WriteFile(yamlFile, []byte("game:\n  maps: [forest, desert]\n"), 0644)
+
+	os.Setenv("LEAF_CONSOLEPROMPT", "game# ")
+	defer os.Unsetenv("LEAF_CONSOLEPROMPT")
+
+	err = conf.Load(jsonFile, yamlFile)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Printf("%v %v %q\n", conf.ConsolePort, conf.HeartbeatInterval, conf.ConsolePrompt)
+
+	game := new(GameConf)
+	conf.Register("game", game, nil)
+	fmt.Println(game.MaxPlayers, game.Maps)
+
+	// invalid values are never applied
+	ioutil.WriteFile(jsonFile, []byte(`{"game": {"MaxPlayers": 0}}`), 0644)
+	fmt.Println(conf.Reload())
+	fmt.Println(game.MaxPlayers)
+
+	// Output:
+	// 3333 2s "game# "
+	// 100 [forest desert]
+	// section game: MaxPlayers must be positive
+	// 100
+}
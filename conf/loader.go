@@ -0,0 +1,563 @@
+package conf
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gitee.com/aarlin/leaflet/log"
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+	"io/ioutil"
+	"math"
+	"os"
+	"path"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Config files are json, toml or yaml, chosen by the file extension.
+// The variables of this package live in the section "leaf", the values
+// registered with Register in their own sections:
+//
+//	{
+//	    "leaf": {"LogLevel": "release", "ConsolePort": 3333},
+//	    "game": {"MaxPlayers": 1000}
+//	}
+//
+// Environment variables override the files: LEAF_CONSOLEPORT sets
+// ConsolePort and LEAF_GAME_MAXPLAYERS sets MaxPlayers of section game.
+// Durations are written as "5s", lists in environment variables as "a,b".
+var EnvPrefix = "LEAF"
+
+const leafSection = "leaf"
+
+// optional interface of a registered value, checked before it is applied
+type Validator interface {
+	Validate() error
+}
+
+type section struct {
+	name     string
+	v        reflect.Value
+	defaults reflect.Value
+	notify   func(apply func()) error
+}
+
+var (
+	mutexLoader  sync.Mutex
+	mutexVars    sync.RWMutex
+	files        []string
+	data         map[string]interface{}
+	sections     = make(map[string]*section)
+	leafDefaults map[string]reflect.Value
+)
+
+// every variable of this package that can be loaded
+func leafVars() map[string]interface{} {
+	return map[string]interface{}{
+		"LenStackBuf":       &LenStackBuf,
+		"LogLevel":          &LogLevel,
+		"LogPath":           &LogPath,
+		"LogFlag":           &LogFlag,
+		"LogNamePrefix":     &LogNamePrefix,
+		"LogKeepHour":       &LogKeepHour,
+		"StopAcceptTimeout": &StopAcceptTimeout,
+		"NotifyTimeout":     &NotifyTimeout,
+		"DrainTimeout":      &DrainTimeout,
+		"DestroyTimeout":    &DestroyTimeout,
+		"ConsolePort":       &ConsolePort,
+		"ConsolePrompt":     &ConsolePrompt,
+		"ProfilePath":       &ProfilePath,
+		"MetricsAddr":       &MetricsAddr,
+		"NodeID":            &NodeID,
+		"NodeType":          &NodeType,
+		"ListenAddr":        &ListenAddr,
+		"AdvertiseAddr":     &AdvertiseAddr,
+		"ConnAddrs":         &ConnAddrs,
+		"PendingWriteNum":   &PendingWriteNum,
+		"HeartbeatInterval": &HeartbeatInterval,
+		"SuspectTimeout":    &SuspectTimeout,
+		"DeadTimeout":       &DeadTimeout,
+	}
+}
+
+// the variables of this package Reload applies, the others are read
+// without locking and change on restart only
+var reloadSafe = map[string]bool{
+	"StopAcceptTimeout": true,
+	"NotifyTimeout":     true,
+	"DrainTimeout":      true,
+	"DestroyTimeout":    true,
+}
+
+// goroutine safe
+//
+// ShutdownTimeouts reads the timeouts of the shutdown phases, safe from
+// a concurrent Reload
+func ShutdownTimeouts() (stopAccept, notify, drain, destroy time.Duration) {
+	mutexVars.RLock()
+	defer mutexVars.RUnlock()
+	return StopAcceptTimeout, NotifyTimeout, DrainTimeout, DestroyTimeout
+}
+
+// Load reads the files and the environment into the variables of this
+// package and the registered values, you must call the function
+// before calling leaf.Run
+func Load(paths ...string) error {
+	mutexLoader.Lock()
+	defer mutexLoader.Unlock()
+
+	files = paths
+	return load(false)
+}
+
+// goroutine safe
+//
+// Reload reads the files given to Load again, nothing is applied
+// unless every section is valid. Of this package only the shutdown
+// timeouts are reloaded; the sections are handed to their notify, the
+// changes to the others are logged and wait for a restart
+func Reload() error {
+	mutexLoader.Lock()
+	defer mutexLoader.Unlock()
+
+	return load(true)
+}
+
+// Register binds the section name to v, a pointer to a struct. The
+// values loaded so far are applied at once, later reloads call notify
+// with a function that applies the new values on the goroutine that owns
+// v; without notify, v changes on Load only
+func Register(name string, v interface{}, notify func(apply func()) error) error {
+	mutexLoader.Lock()
+	defer mutexLoader.Unlock()
+
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("section %v: pointer to struct required", name)
+	}
+	if strings.EqualFold(name, leafSection) {
+		return fmt.Errorf("section %v: reserved", name)
+	}
+	if _, ok := sections[strings.ToLower(name)]; ok {
+		return fmt.Errorf("section %v: already registered", name)
+	}
+
+	s := new(section)
+	s.name = name
+	s.v = rv
+	s.defaults = reflect.New(rv.Type().Elem()).Elem()
+	s.defaults.Set(rv.Elem())
+	s.notify = notify
+
+	fresh, err := s.decode(data)
+	if err != nil {
+		return err
+	}
+	rv.Elem().Set(fresh.Elem())
+
+	sections[strings.ToLower(name)] = s
+	return nil
+}
+
+func load(reload bool) error {
+	d, err := readFiles(files)
+	if err != nil {
+		return err
+	}
+
+	// variables of this package
+	if leafDefaults == nil {
+		leafDefaults = make(map[string]reflect.Value)
+		for name, p := range leafVars() {
+			v := reflect.New(reflect.TypeOf(p).Elem()).Elem()
+			v.Set(reflect.ValueOf(p).Elem())
+			leafDefaults[name] = v
+		}
+	}
+	leaf, err := decodeLeaf(d)
+	if err != nil {
+		return err
+	}
+
+	// sections
+	fresh := make(map[*section]reflect.Value)
+	for _, s := range sections {
+		v, err := s.decode(d)
+		if err != nil {
+			return err
+		}
+		fresh[s] = v
+	}
+
+	// apply
+	data = d
+	vars := leafVars()
+	var restart []string
+	mutexVars.Lock()
+	for name, v := range leaf {
+		p := reflect.ValueOf(vars[name]).Elem()
+		if reload && !reloadSafe[name] {
+			if !reflect.DeepEqual(p.Interface(), v.Interface()) {
+				restart = append(restart, leafSection+"."+name)
+			}
+			continue
+		}
+		p.Set(v)
+	}
+	mutexVars.Unlock()
+
+	var errs []string
+	for s, v := range fresh {
+		s, v := s, v
+		apply := func() {
+			s.v.Elem().Set(v.Elem())
+		}
+		if !reload {
+			apply()
+		} else if s.notify == nil {
+			if !reflect.DeepEqual(s.v.Elem().Interface(), v.Elem().Interface()) {
+				restart = append(restart, s.name)
+			}
+		} else if err := s.notify(apply); err != nil {
+			errs = append(errs, fmt.Sprintf("section %v: %v", s.name, err))
+		}
+	}
+	if len(restart) > 0 {
+		sort.Strings(restart)
+		log.ReleaseF("config changes applied on restart: %v", strings.Join(restart, ", "))
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+func decodeLeaf(d map[string]interface{}) (map[string]reflect.Value, error) {
+	src, _ := lookup(d, leafSection).(map[string]interface{})
+	for key := range src {
+		if leafName(key) == "" {
+			return nil, fmt.Errorf("%v: unknown field %v", leafSection, key)
+		}
+	}
+
+	leaf := make(map[string]reflect.Value)
+	for name, def := range leafDefaults {
+		v := reflect.New(def.Type()).Elem()
+		v.Set(def)
+
+		p := leafSection + "." + name
+		if value := lookup(src, name); value != nil {
+			if err := setValue(v, value, p); err != nil {
+				return nil, err
+			}
+		}
+		if env, ok := os.LookupEnv(EnvPrefix + "_" + strings.ToUpper(name)); ok {
+			if err := setValue(v, env, p); err != nil {
+				return nil, err
+			}
+		}
+		leaf[name] = v
+	}
+
+	switch strings.ToLower(leaf["LogLevel"].String()) {
+	case "", "debug", "release", "error", "fatal":
+	default:
+		return nil, fmt.Errorf("%v.LogLevel: unknown level %v", leafSection, leaf["LogLevel"])
+	}
+	if port := leaf["ConsolePort"].Int(); port < 0 || port > math.MaxUint16 {
+		return nil, fmt.Errorf("%v.ConsolePort: invalid port %v", leafSection, port)
+	}
+	return leaf, nil
+}
+
+func leafName(key string) string {
+	for name := range leafDefaults {
+		if strings.EqualFold(name, key) {
+			return name
+		}
+	}
+	return ""
+}
+
+func (s *section) decode(d map[string]interface{}) (reflect.Value, error) {
+	v := reflect.New(s.defaults.Type())
+	v.Elem().Set(s.defaults)
+
+	if src := lookup(d, s.name); src != nil {
+		if err := setValue(v.Elem(), src, s.name); err != nil {
+			return v, err
+		}
+	}
+	if err := setEnv(v.Elem(), EnvPrefix+"_"+strings.ToUpper(s.name), s.name); err != nil {
+		return v, err
+	}
+
+	if validator, ok := v.Interface().(Validator); ok {
+		if err := validator.Validate(); err != nil {
+			return v, fmt.Errorf("section %v: %v", s.name, err)
+		}
+	}
+	return v, nil
+}
+
+func readFiles(paths []string) (map[string]interface{}, error) {
+	d := make(map[string]interface{})
+	for _, p := range paths {
+		b, err := ioutil.ReadFile(p)
+		if err != nil {
+			return nil, err
+		}
+
+		var m map[string]interface{}
+		switch strings.ToLower(path.Ext(p)) {
+		case ".json":
+			err = json.Unmarshal(b, &m)
+		case ".toml":
+			err = toml.Unmarshal(b, &m)
+		case ".yaml", ".yml":
+			err = yaml.Unmarshal(b, &m)
+		default:
+			err = errors.New("unknown config format")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%v: %v", p, err)
+		}
+
+		merge(d, m)
+	}
+	return d, nil
+}
+
+// later files override earlier ones
+func merge(dst map[string]interface{}, src map[string]interface{}) {
+	for k, v := range src {
+		if m, ok := v.(map[string]interface{}); ok {
+			if d, ok := dst[k].(map[string]interface{}); ok {
+				merge(d, m)
+				continue
+			}
+		}
+		dst[k] = v
+	}
+}
+
+func lookup(m map[string]interface{}, key string) interface{} {
+	if v, ok := m[key]; ok {
+		return v
+	}
+	for k, v := range m {
+		if strings.EqualFold(k, key) {
+			return v
+		}
+	}
+	return nil
+}
+
+func fieldName(f reflect.StructField) string {
+	if tag := f.Tag.Get("conf"); tag != "" {
+		return tag
+	}
+	return f.Name
+}
+
+func setEnv(v reflect.Value, prefix string, p string) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" || f.Tag.Get("conf") == "-" {
+			continue
+		}
+
+		name := fieldName(f)
+		env := prefix + "_" + strings.ToUpper(name)
+		if f.Type.Kind() == reflect.Struct && f.Type != reflect.TypeOf(time.Time{}) {
+			if err := setEnv(v.Field(i), env, p+"."+name); err != nil {
+				return err
+			}
+			continue
+		}
+		if value, ok := os.LookupEnv(env); ok {
+			if err := setValue(v.Field(i), value, p+"."+name); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+func setValue(v reflect.Value, src interface{}, p string) error {
+	if src == nil {
+		v.Set(reflect.Zero(v.Type()))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr:
+		e := reflect.New(v.Type().Elem())
+		if !v.IsNil() {
+			e.Elem().Set(v.Elem())
+		}
+		if err := setValue(e.Elem(), src, p); err != nil {
+			return err
+		}
+		v.Set(e)
+	case reflect.Interface:
+		v.Set(reflect.ValueOf(src))
+	case reflect.String:
+		s, ok := src.(string)
+		if !ok {
+			return fmt.Errorf("%v: string required, got %v", p, src)
+		}
+		v.SetString(s)
+	case reflect.Bool:
+		switch b := src.(type) {
+		case bool:
+			v.SetBool(b)
+		case string:
+			parsed, err := strconv.ParseBool(b)
+			if err != nil {
+				return fmt.Errorf("%v: %v", p, err)
+			}
+			v.SetBool(parsed)
+		default:
+			return fmt.Errorf("%v: bool required, got %v", p, src)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		var i int64
+		var err error
+		if s, ok := src.(string); ok && v.Type() == durationType {
+			var d time.Duration
+			d, err = time.ParseDuration(s)
+			i = int64(d)
+		} else {
+			i, err = toInt64(src)
+		}
+		if err != nil {
+			return fmt.Errorf("%v: %v", p, err)
+		}
+		if v.OverflowInt(i) {
+			return fmt.Errorf("%v: %v overflows %v", p, i, v.Type())
+		}
+		v.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		i, err := toInt64(src)
+		if err != nil {
+			return fmt.Errorf("%v: %v", p, err)
+		}
+		if i < 0 || v.OverflowUint(uint64(i)) {
+			return fmt.Errorf("%v: %v overflows %v", p, i, v.Type())
+		}
+		v.SetUint(uint64(i))
+	case reflect.Float32, reflect.Float64:
+		var f float64
+		switch n := src.(type) {
+		case float64:
+			f = n
+		case int:
+			f = float64(n)
+		case int64:
+			f = float64(n)
+		case string:
+			parsed, err := strconv.ParseFloat(n, 64)
+			if err != nil {
+				return fmt.Errorf("%v: %v", p, err)
+			}
+			f = parsed
+		default:
+			return fmt.Errorf("%v: number required, got %v", p, src)
+		}
+		v.SetFloat(f)
+	case reflect.Slice:
+		if s, ok := src.(string); ok {
+			var items []interface{}
+			for _, item := range strings.Split(s, ",") {
+				if item = strings.TrimSpace(item); item != "" {
+					items = append(items, item)
+				}
+			}
+			src = items
+		}
+		rs := reflect.ValueOf(src)
+		if rs.Kind() != reflect.Slice {
+			return fmt.Errorf("%v: list required, got %v", p, src)
+		}
+		slice := reflect.MakeSlice(v.Type(), rs.Len(), rs.Len())
+		for i := 0; i < rs.Len(); i++ {
+			if err := setValue(slice.Index(i), rs.Index(i).Interface(), fmt.Sprintf("%v[%v]", p, i)); err != nil {
+				return err
+			}
+		}
+		v.Set(slice)
+	case reflect.Map:
+		m, ok := src.(map[string]interface{})
+		if !ok || v.Type().Key().Kind() != reflect.String {
+			return fmt.Errorf("%v: table required, got %v", p, src)
+		}
+		dst := reflect.MakeMap(v.Type())
+		for _, k := range v.MapKeys() {
+			dst.SetMapIndex(k, v.MapIndex(k))
+		}
+		for k, value := range m {
+			e := reflect.New(v.Type().Elem()).Elem()
+			if err := setValue(e, value, p+"."+k); err != nil {
+				return err
+			}
+			dst.SetMapIndex(reflect.ValueOf(k).Convert(v.Type().Key()), e)
+		}
+		v.Set(dst)
+	case reflect.Struct:
+		m, ok := src.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%v: table required, got %v", p, src)
+		}
+		t := v.Type()
+	keys:
+		for k, value := range m {
+			for i := 0; i < t.NumField(); i++ {
+				f := t.Field(i)
+				if f.PkgPath != "" || f.Tag.Get("conf") == "-" {
+					continue
+				}
+				if name := fieldName(f); strings.EqualFold(name, k) {
+					if err := setValue(v.Field(i), value, p+"."+name); err != nil {
+						return err
+					}
+					continue keys
+				}
+			}
+			return fmt.Errorf("%v: unknown field %v", p, k)
+		}
+	default:
+		return fmt.Errorf("%v: unsupported type %v", p, v.Type())
+	}
+	return nil
+}
+
+func toInt64(src interface{}) (int64, error) {
+	switch n := src.(type) {
+	case int:
+		return int64(n), nil
+	case int64:
+		return n, nil
+	case uint64:
+		if n > math.MaxInt64 {
+			return 0, fmt.Errorf("%v overflows int64", n)
+		}
+		return int64(n), nil
+	case float64:
+		if n != math.Trunc(n) || n < math.MinInt64 || n > math.MaxInt64 {
+			return 0, fmt.Errorf("integer required, got %v", n)
+		}
+		return int64(n), nil
+	case string:
+		return strconv.ParseInt(n, 0, 64)
+	}
+	return 0, fmt.Errorf("integer required, got %v", src)
+}
@@ -5,9 +5,11 @@ import (
 	"gitee.com/aarlin/leaflet/chanrpc"
 	"gitee.com/aarlin/leaflet/conf"
 	"gitee.com/aarlin/leaflet/log"
+	"gitee.com/aarlin/leaflet/metrics"
 	"os"
 	"path"
 	"runtime/pprof"
+	"strings"
 	"time"
 )
 
@@ -15,6 +17,8 @@ var commands = []Command{
 	new(CommandHelp),
 	new(CommandCPUProf),
 	new(CommandProf),
+	new(CommandReload),
+	new(CommandMetrics),
 }
 
 type Command interface {
@@ -213,3 +217,44 @@ func (c *CommandProf) run(args []string) string {
 
 	return fn
 }
+
+// reload
+type CommandReload struct{}
+
+func (c *CommandReload) name() string {
+	return "reload"
+}
+
+func (c *CommandReload) help() string {
+	return "reload the config files"
+}
+
+func (c *CommandReload) run([]string) string {
+	err := conf.Reload()
+	if err != nil {
+		return err.Error()
+	}
+	return "config reloaded"
+}
+
+// metrics
+type CommandMetrics struct{}
+
+func (c *CommandMetrics) name() string {
+	return "metrics"
+}
+
+func (c *CommandMetrics) help() string {
+	return "dump the metrics, optionally those starting with a prefix"
+}
+
+func (c *CommandMetrics) run(args []string) string {
+	var prefix string
+	if len(args) > 0 {
+		prefix = args[0]
+	}
+
+	var b strings.Builder
+	metrics.WriteText(&b, prefix)
+	return strings.Replace(strings.TrimSuffix(b.String(), "\n"), "\n", "\r\n", -1)
+}
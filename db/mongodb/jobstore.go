@@ -0,0 +1,49 @@
+package mongodb
+
+import (
+	"github.com/mongodb/mongo-go-driver/x/bsonx"
+	"time"
+)
+
+// JobStore keeps the last run times of the timer.Scheduler jobs in a
+// table, one document per job
+type JobStore struct {
+	repo  *MongoRepo
+	table string
+}
+
+type jobRecord struct {
+	Name string    `bson:"_id"`
+	Last time.Time `bson:"last"`
+}
+
+func NewJobStore(repo *MongoRepo, table string) *JobStore {
+	return &JobStore{repo: repo, table: table}
+}
+
+func (s *JobStore) Load() (map[string]time.Time, error) {
+	ctx, cursor, err := s.repo.Find(s.table, bsonx.Doc{})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	lasts := make(map[string]time.Time)
+	for cursor.Next(ctx) {
+		var record jobRecord
+		if err := cursor.Decode(&record); err != nil {
+			return nil, err
+		}
+		lasts[record.Name] = record.Last
+	}
+	return lasts, cursor.Err()
+}
+
+func (s *JobStore) Save(name string, last time.Time) error {
+	filter := bsonx.Doc{bsonx.Elem{Key: "_id", Value: bsonx.String(name)}}
+	update := bsonx.Doc{bsonx.Elem{Key: "$set", Value: bsonx.Document(bsonx.Doc{
+		bsonx.Elem{Key: "last", Value: bsonx.Time(last)},
+	})}}
+	_, err := s.repo.UpdateOneOrInsert(s.table, filter, update)
+	return err
+}
@@ -5,7 +5,13 @@ import (
 )
 
 type Agent interface {
-	WriteMsg(msg interface{})
+	// WriteMsg returns network.ErrWriteFull, ErrWriteTimeout or ErrMsgDropped
+	// when the peer does not keep up, by the overflow policy of the server
+	WriteMsg(msg interface{}) error
+	// sent on the datagram channel, may be lost
+	WriteUnreliable(msg interface{})
+	// handed to the client to open the datagram channel, 0 without it
+	DatagramToken() uint64
 	LocalAddr() net.Addr
 	RemoteAddr() net.Addr
 	Close()
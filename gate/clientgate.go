@@ -9,6 +9,7 @@ import (
 type ClientGate struct {
 	wsClient *network.WSClient
 	tcpClient *network.TCPClient
+	udpClient *network.UDPClient
 
 	ConnNum      int
 	PendingWriteNum int
@@ -29,8 +30,20 @@ type ClientGate struct {
 	// tcp
 	TCPAddr      string
 	TcpParser network.TcpParser
+	// an idle server is disconnected, 0 never
+	TCPReadTimeout  time.Duration
+	TCPWriteTimeout time.Duration
+	// the server answers the pings if its heartbeat is on too
+	TCPPingInterval time.Duration
 	//LenMsgLen    int
 	//LittleEndian bool
+
+	// reliable udp
+	UDPAddr string
+	// the period of the acks and the retransmissions
+	UDPInterval time.Duration
+	// an idle server is disconnected
+	UDPIdleTimeout time.Duration
 }
 
 func (c *ClientGate) Run() {
@@ -65,6 +78,9 @@ func (c *ClientGate) Run() {
 		tcpClient.ConnectInterval = c.ConnectInterval
 		tcpClient.AutoReconnect = c.AutoReconnect
 		tcpClient.TcpParser = c.TcpParser
+		tcpClient.ReadTimeout = c.TCPReadTimeout
+		tcpClient.WriteTimeout = c.TCPWriteTimeout
+		tcpClient.PingInterval = c.TCPPingInterval
 		tcpClient.NewAgent = func(conn *network.TCPConn) network.Agent {
 			a := &agent{conn: conn, closeAgentName: c.CloseAgentName,processor: c.Processor,agentChanRPC: c.AgentChanRPC}
 			if c.AgentChanRPC != nil {
@@ -74,6 +90,27 @@ func (c *ClientGate) Run() {
 		}
 	}
 
+	var udpClient *network.UDPClient
+	if c.UDPAddr != "" {
+		udpClient = new(network.UDPClient)
+		udpClient.Addr = c.UDPAddr
+		udpClient.ConnNum = c.ConnNum
+		udpClient.PendingWriteNum = c.PendingWriteNum
+		udpClient.MaxMsgLen = c.MaxMsgLen
+		udpClient.ConnectInterval = c.ConnectInterval
+		udpClient.AutoReconnect = c.AutoReconnect
+		udpClient.HandshakeTimeout = c.HTTPTimeout
+		udpClient.Interval = c.UDPInterval
+		udpClient.IdleTimeout = c.UDPIdleTimeout
+		udpClient.NewAgent = func(conn *network.UDPConn) network.Agent {
+			a := &agent{conn: conn, closeAgentName: c.CloseAgentName,processor: c.Processor,agentChanRPC: c.AgentChanRPC}
+			if c.AgentChanRPC != nil {
+				c.AgentChanRPC.Go(c.NewAgentName, a)
+			}
+			return a
+		}
+	}
+
 	if wsClient != nil {
 		wsClient.Start()
 	}
@@ -83,6 +120,11 @@ func (c *ClientGate) Run() {
 		tcpClient.Start()
 	}
 	c.tcpClient = tcpClient
+
+	if udpClient != nil {
+		udpClient.Start()
+	}
+	c.udpClient = udpClient
 }
 
 func (c *ClientGate) init()  {
@@ -99,6 +141,10 @@ func (c *ClientGate) Stop()  {
 		c.wsClient.Close()
 	}
 	c.wsClient = nil
+	if c.udpClient != nil {
+		c.udpClient.Close()
+	}
+	c.udpClient = nil
 }
 
 func (c *ClientGate) OnDestroy() {}
\ No newline at end of file
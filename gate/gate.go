@@ -1,6 +1,7 @@
 package gate
 
 import (
+	"errors"
 	"gitee.com/aarlin/leaflet/chanrpc"
 	"gitee.com/aarlin/leaflet/log"
 	"gitee.com/aarlin/leaflet/network"
@@ -15,9 +16,13 @@ type agent struct {
 	processor       network.Processor
 	agentChanRPC    *chanrpc.Server
 	userData interface{}
+	onClose  func()
+	// the datagram channel, nil without DatagramAddr
+	datagram *network.DatagramSession
 }
 
 func (a *agent) Run() {
+	agentsGauge.Inc()
 	for {
 		data, err := a.conn.ReadMsg()
 		if err != nil {
@@ -25,52 +30,137 @@ func (a *agent) Run() {
 			break
 		}
 		//log.Debug("read message: %v", data)
-		if a.processor != nil {
-			msg, err := a.processor.Unmarshal(data)
-			if err != nil {
-				log.Debug("unmarshal message error: %v", err)
-				break
-			}
-			err = a.processor.Route(msg, a)
-			if err != nil {
-				log.Debug("route message error: %v", err)
-				break
-			}
+		if a.route(data) != nil {
+			break
 		}
 	}
 }
 
+// route unmarshals and routes a message of the peer
+func (a *agent) route(data []byte) error {
+	if a.processor == nil {
+		return nil
+	}
+	msg, err := a.processor.Unmarshal(data)
+	if err != nil {
+		log.Debug("unmarshal message error: %v", err)
+		msgErrors("unmarshal").Inc()
+		return err
+	}
+	err = a.processor.Route(msg, a)
+	if err != nil {
+		log.Debug("route message error: %v", err)
+		msgErrors("route").Inc()
+		return err
+	}
+	msgsIn.Inc()
+	return nil
+}
+
 func (a *agent) OnClose() {
+	agentsGauge.Dec()
 	if a.agentChanRPC != nil {
 		err := a.agentChanRPC.Call0(a.closeAgentName, a)
 		if err != nil {
 			log.Error("chanrpc error: %v", err)
 		}
 	}
+	if a.onClose != nil {
+		a.onClose()
+	}
 }
 
-func (a *agent) WriteMsg(msg interface{}) {
-	var data [][]byte
-	var err error
-	if a.processor != nil {
-		data, err = a.processor.Marshal(msg)
-		if err != nil {
-			log.Error("marshal message %v error: %v", reflect.TypeOf(msg), err)
-			return
-		}
+var errProcessorRequired = errors.New("processor required")
 
-	}else{
-		if data2,ok := msg.([][]byte); !ok {
-			log.Error("marshal message %v error: %v", reflect.TypeOf(msg), err)
-			return
-		}else{
-			data = data2
+func (a *agent) marshal(msg interface{}) ([][]byte, error) {
+	if a.processor == nil {
+		data, ok := msg.([][]byte)
+		if !ok {
+			log.ErrorF("marshal message %v error: %v", reflect.TypeOf(msg), errProcessorRequired)
+			return nil, errProcessorRequired
 		}
+		return data, nil
+	}
+	data, err := a.processor.Marshal(msg)
+	if err != nil {
+		log.Error("marshal message %v error: %v", reflect.TypeOf(msg), err)
+		msgErrors("marshal").Inc()
+		return nil, err
+	}
+	return data, nil
+}
+
+func (a *agent) WriteMsg(msg interface{}) error {
+	data, err := a.marshal(msg)
+	if err != nil {
+		return err
 	}
 	err = a.conn.WriteMsg(data...)
+	if err == network.ErrMsgDropped || err == network.ErrWriteTimeout {
+		// by the overflow policy of the server
+		log.DebugF("write message %v error: %v", reflect.TypeOf(msg), err)
+		msgErrors("dropped").Inc()
+		return err
+	}
 	if err != nil {
 		log.Error("write message %v error: %v", reflect.TypeOf(msg), err)
+		msgErrors("write").Inc()
+		return err
+	}
+	msgsOut.Inc()
+	return nil
+}
+
+// WriteUnreliable sends msg on the datagram channel, msg may be lost or
+// arrive late and be dropped, and is dropped before the peer's first datagram
+func (a *agent) WriteUnreliable(msg interface{}) {
+	if a.datagram == nil {
+		return
+	}
+	data, err := a.marshal(msg)
+	if err != nil {
+		return
+	}
+	err = a.datagram.WriteMsg(data...)
+	if err != nil {
+		log.DebugF("write datagram %v error: %v", reflect.TypeOf(msg), err)
+		msgErrors("write_datagram").Inc()
+		return
+	}
+	msgsOut.Inc()
+}
+
+// DatagramToken is 0 without the datagram channel
+func (a *agent) DatagramToken() uint64 {
+	if a.datagram == nil {
+		return 0
+	}
+	return a.datagram.Token()
+}
+
+// onDatagram runs on the reader goroutine of the datagram server,
+// the bad datagrams are dropped and the session is kept
+func (a *agent) onDatagram(data []byte) {
+	if a.processor == nil {
+		return
+	}
+	msg, err := a.processor.Unmarshal(data)
+	if err != nil {
+		log.DebugF("unmarshal datagram error: %v", err)
+		msgErrors("unmarshal_datagram").Inc()
+		return
+	}
+	if p, ok := a.processor.(network.DatagramProcessor); ok {
+		err = p.RouteDatagram(msg, a)
+	} else {
+		err = a.processor.Route(msg, a)
+	}
+	if err != nil {
+		log.DebugF("route datagram error: %v", err)
+		msgErrors("route_datagram").Inc()
+		return
 	}
+	msgsIn.Inc()
 }
 
 func (a *agent) LocalAddr() net.Addr {
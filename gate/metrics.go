@@ -0,0 +1,13 @@
+package gate
+
+import "gitee.com/aarlin/leaflet/metrics"
+
+var (
+	agentsGauge = metrics.NewGauge("gate_agents", "agents connected to the gates")
+	msgsIn      = metrics.NewCounter("gate_msgs_received_total", "messages routed from the agents")
+	msgsOut     = metrics.NewCounter("gate_msgs_sent_total", "messages written to the agents")
+)
+
+func msgErrors(op string) *metrics.Counter {
+	return metrics.NewCounter("gate_msg_errors_total", "messages the agents failed to handle", "op", op)
+}
@@ -2,7 +2,9 @@ package gate
 
 import (
 	"gitee.com/aarlin/leaflet/chanrpc"
+	"gitee.com/aarlin/leaflet/log"
 	"gitee.com/aarlin/leaflet/network"
+	"sync"
 	"time"
 )
 
@@ -13,9 +15,23 @@ type ServerGate struct {
 	Processor       network.Processor
 	AgentChanRPC    *chanrpc.Server
 
+	// when PendingWriteNum messages are waiting for a tcp or websocket peer,
+	// the drop policies would break the resumable sessions
+	OverflowPolicy    network.OverflowPolicy
+	WriteBlockTimeout time.Duration
+	WritePriority     func(args [][]byte) int
+
 	NewAgentName	string
 	CloseAgentName	string
 
+	// sent to every agent when the server is closing
+	ClosingMsg interface{}
+
+	// resumable sessions, the agents outlive their connections for
+	// ResumeGrace and the clients speak the session frames
+	ResumeGrace     time.Duration
+	ResumeBufferLen int
+
 	// websocket
 	WSAddr      string
 	HTTPTimeout time.Duration
@@ -25,11 +41,48 @@ type ServerGate struct {
 	// tcp
 	TCPAddr      string
 	TcpParser network.TcpParser
+	// idle peers are disconnected, 0 never
+	TCPReadTimeout  time.Duration
+	TCPWriteTimeout time.Duration
+	// the peers answer the pings if their heartbeat is on too
+	TCPPingInterval time.Duration
+
+	// reliable udp
+	UDPAddr string
+	// the period of the acks and the retransmissions
+	UDPInterval time.Duration
+	// idle peers are disconnected
+	UDPIdleTimeout time.Duration
+
+	// unreliable datagrams beside the sessions above
+	DatagramAddr      string
+	DatagramMaxMsgLen uint32
+
+	wsServer    *network.WSServer
+	tcpServer   *network.TCPServer
+	udpServer   *network.UDPServer
+	datagramServer *network.DatagramServer
+	agents      map[*agent]struct{}
+	sessions    map[uint64]*session
+	closing     bool
+	mutexAgents sync.Mutex
 }
 
 func (gate *ServerGate) Run(closeSig chan bool) {
 	gate.init()
 
+	// before the sessions, which are paired with it
+	var datagramServer *network.DatagramServer
+	if gate.DatagramAddr != "" {
+		datagramServer = new(network.DatagramServer)
+		datagramServer.Addr = gate.DatagramAddr
+		datagramServer.MaxMsgLen = gate.DatagramMaxMsgLen
+		datagramServer.Start()
+	}
+	gate.mutexAgents.Lock()
+	gate.datagramServer = datagramServer
+	gate.mutexAgents.Unlock()
+
 	var wsServer *network.WSServer
 	if gate.WSAddr != "" {
 		wsServer = new(network.WSServer)
@@ -40,12 +93,11 @@ func (gate *ServerGate) Run(closeSig chan bool) {
 		wsServer.HTTPTimeout = gate.HTTPTimeout
 		wsServer.CertFile = gate.CertFile
 		wsServer.KeyFile = gate.KeyFile
+		wsServer.OverflowPolicy = gate.OverflowPolicy
+		wsServer.WriteBlockTimeout = gate.WriteBlockTimeout
+		wsServer.WritePriority = gate.WritePriority
 		wsServer.NewAgent = func(conn *network.WSConn) network.Agent {
-			a := &agent{conn: conn, closeAgentName: gate.CloseAgentName,processor:gate.Processor,agentChanRPC:gate.AgentChanRPC}
-			if gate.AgentChanRPC != nil {
-				gate.AgentChanRPC.Go(gate.NewAgentName, a)
-			}
-			return a
+			return gate.newAgent(conn)
 		}
 	}
 
@@ -56,15 +108,31 @@ func (gate *ServerGate) Run(closeSig chan bool) {
 		tcpServer.MaxConnNum = gate.MaxConnNum
 		tcpServer.PendingWriteNum = gate.PendingWriteNum
 		tcpServer.TcpParser = gate.TcpParser
+		tcpServer.ReadTimeout = gate.TCPReadTimeout
+		tcpServer.WriteTimeout = gate.TCPWriteTimeout
+		tcpServer.PingInterval = gate.TCPPingInterval
+		tcpServer.OverflowPolicy = gate.OverflowPolicy
+		tcpServer.WriteBlockTimeout = gate.WriteBlockTimeout
+		tcpServer.WritePriority = gate.WritePriority
 		//tcpServer.LenMsgLen = gate.LenMsgLen
 		//tcpServer.MaxMsgLen = gate.MaxMsgLen
 		//tcpServer.LittleEndian = gate.LittleEndian
 		tcpServer.NewAgent = func(conn *network.TCPConn) network.Agent {
-			a := &agent{conn: conn, closeAgentName: gate.CloseAgentName,processor:gate.Processor,agentChanRPC:gate.AgentChanRPC}
-			if gate.AgentChanRPC != nil {
-				gate.AgentChanRPC.Go(gate.NewAgentName, a)
-			}
-			return a
+			return gate.newAgent(conn)
+		}
+	}
+
+	var udpServer *network.UDPServer
+	if gate.UDPAddr != "" {
+		udpServer = new(network.UDPServer)
+		udpServer.Addr = gate.UDPAddr
+		udpServer.MaxConnNum = gate.MaxConnNum
+		udpServer.PendingWriteNum = gate.PendingWriteNum
+		udpServer.MaxMsgLen = gate.MaxMsgLen
+		udpServer.Interval = gate.UDPInterval
+		udpServer.IdleTimeout = gate.UDPIdleTimeout
+		udpServer.NewAgent = func(conn *network.UDPConn) network.Agent {
+			return gate.newAgent(conn)
 		}
 	}
 
@@ -74,13 +142,32 @@ func (gate *ServerGate) Run(closeSig chan bool) {
 	if tcpServer != nil {
 		tcpServer.Start()
 	}
+	if udpServer != nil {
+		udpServer.Start()
+	}
+	gate.mutexAgents.Lock()
+	gate.wsServer = wsServer
+	gate.tcpServer = tcpServer
+	gate.udpServer = udpServer
+	gate.mutexAgents.Unlock()
+
 	<-closeSig
+	gate.mutexAgents.Lock()
+	gate.closing = true
+	gate.mutexAgents.Unlock()
 	if wsServer != nil {
 		wsServer.Close()
 	}
 	if tcpServer != nil {
 		tcpServer.Close()
 	}
+	if udpServer != nil {
+		udpServer.Close()
+	}
+	gate.endSessions()
+	if datagramServer != nil {
+		datagramServer.Close()
+	}
 }
 
 
@@ -91,6 +178,107 @@ func (gate *ServerGate) init()  {
 	if  gate.NewAgentName == ""{
 		gate.NewAgentName = "NewAgent"
 	}
+	if gate.ResumeGrace > 0 && gate.ResumeBufferLen <= 0 {
+		gate.ResumeBufferLen = 256
+		log.ReleaseF("invalid ResumeBufferLen, reset to %v", gate.ResumeBufferLen)
+	}
+	// a session frame dropped from the write queue is never sent again
+	if gate.ResumeGrace > 0 && (gate.OverflowPolicy == network.OverflowDropOldest ||
+		gate.OverflowPolicy == network.OverflowDropPriority) {
+		log.FatalF("OverflowPolicy %v not allowed with ResumeGrace", gate.OverflowPolicy)
+	}
+	gate.agents = make(map[*agent]struct{})
+	gate.sessions = make(map[uint64]*session)
+	gate.closing = false
+}
+
+func (gate *ServerGate) newAgent(conn network.Conn) network.Agent {
+	if gate.ResumeGrace > 0 {
+		// the agent comes with the hello of the client
+		return &transport{gate: gate, conn: conn}
+	}
+
+	a := &agent{conn: conn, closeAgentName: gate.CloseAgentName,processor:gate.Processor,agentChanRPC:gate.AgentChanRPC}
+	gate.addAgent(a)
+	if gate.AgentChanRPC != nil {
+		gate.AgentChanRPC.Go(gate.NewAgentName, a)
+	}
+	return a
+}
+
+// endSessions ends the sessions waiting for their clients
+func (gate *ServerGate) endSessions() {
+	gate.mutexAgents.Lock()
+	sessions := make([]*session, 0, len(gate.sessions))
+	for _, s := range gate.sessions {
+		sessions = append(sessions, s)
+	}
+	gate.mutexAgents.Unlock()
+
+	for _, s := range sessions {
+		s.Destroy()
+	}
+}
+
+func (gate *ServerGate) addAgent(a *agent) {
+	gate.mutexAgents.Lock()
+	gate.agents[a] = struct{}{}
+	datagramServer := gate.datagramServer
+	gate.mutexAgents.Unlock()
+
+	if datagramServer != nil {
+		a.datagram = datagramServer.NewSession(a.onDatagram)
+	}
+	a.onClose = func() {
+		if a.datagram != nil {
+			a.datagram.Close()
+		}
+		gate.mutexAgents.Lock()
+		delete(gate.agents, a)
+		gate.mutexAgents.Unlock()
+	}
+}
+
+// StopAccept closes the listeners and keeps the agents
+func (gate *ServerGate) StopAccept() {
+	gate.mutexAgents.Lock()
+	wsServer, tcpServer, udpServer := gate.wsServer, gate.tcpServer, gate.udpServer
+	gate.mutexAgents.Unlock()
+
+	if wsServer != nil {
+		wsServer.StopAccept()
+	}
+	if tcpServer != nil {
+		tcpServer.StopAccept()
+	}
+	if udpServer != nil {
+		udpServer.StopAccept()
+	}
+}
+
+// NotifyClosing sends ClosingMsg to every agent
+func (gate *ServerGate) NotifyClosing() {
+	if gate.ClosingMsg == nil {
+		return
+	}
+
+	gate.mutexAgents.Lock()
+	agents := make([]*agent, 0, len(gate.agents))
+	for a := range gate.agents {
+		agents = append(agents, a)
+	}
+	gate.mutexAgents.Unlock()
+
+	for _, a := range agents {
+		a.WriteMsg(gate.ClosingMsg)
+	}
+}
+
+// goroutine safe
+func (gate *ServerGate) Drained() bool {
+	gate.mutexAgents.Lock()
+	defer gate.mutexAgents.Unlock()
+	return len(gate.agents) == 0
 }
 
 func (gate *ServerGate) OnDestroy() {}
@@ -0,0 +1,393 @@
+package gate
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"gitee.com/aarlin/leaflet/log"
+	"gitee.com/aarlin/leaflet/network"
+	"net"
+	"sync"
+	"time"
+)
+
+// Resumable sessions, ServerGate.ResumeGrace > 0. Every message on the
+// transport starts with a kind, the integers are little endian:
+//
+//	hello | 1 | token(8) | ack(4) |
+//	data  | 2 | seq(4) | message  |
+//	ack   | 3 | ack(4) |
+//
+// The client says hello first, with token 0 for a new session, or with the
+// token of the session and the seq of the last message it received to
+// resume it. The server replies hello with the token of the session and the
+// seq of the last client message it received, then sends again the messages
+// after the ack of the client. A token other than the one asked for is a
+// new session. The client acks now and then, the server keeps the messages
+// not acked, up to ResumeBufferLen.
+const (
+	sessionHello = 1
+	sessionData  = 2
+	sessionAck   = 3
+
+	sessionHelloLen = 13
+	sessionDataLen  = 5
+	sessionAckLen   = 5
+)
+
+var errSessionFrame = errors.New("invalid session frame")
+
+// seq a is newer than b, with wraparound
+func seqNewer(a, b uint32) bool {
+	return int32(a-b) > 0
+}
+
+func sessionHelloFrame(token uint64, ack uint32) []byte {
+	b := make([]byte, sessionHelloLen)
+	b[0] = sessionHello
+	binary.LittleEndian.PutUint64(b[1:], token)
+	binary.LittleEndian.PutUint32(b[9:], ack)
+	return b
+}
+
+// session is the logical conn of an agent, it outlives the transports
+type session struct {
+	sync.Mutex
+	gate       *ServerGate
+	token      uint64
+	agent      *agent
+	conn       network.Conn
+	localAddr  net.Addr
+	remoteAddr net.Addr
+	sendSeq    uint32
+	recvSeq    uint32
+	// the data frames the client has not acked, the last one is sendSeq
+	pending [][][]byte
+	grace   *time.Timer
+	// counts the detaches, a late grace timer is stale
+	detaches int
+	closing  bool
+	ended    bool
+}
+
+func (gate *ServerGate) newSession() *session {
+	s := &session{gate: gate}
+	s.agent = &agent{conn: s, closeAgentName: gate.CloseAgentName, processor: gate.Processor, agentChanRPC: gate.AgentChanRPC}
+
+	gate.mutexAgents.Lock()
+	for {
+		var b [8]byte
+		if _, err := rand.Read(b[:]); err != nil {
+			log.Fatal("%v", err)
+		}
+		s.token = binary.LittleEndian.Uint64(b[:])
+		if _, ok := gate.sessions[s.token]; !ok && s.token != 0 {
+			break
+		}
+	}
+	gate.sessions[s.token] = s
+	gate.mutexAgents.Unlock()
+
+	gate.addAgent(s.agent)
+	return s
+}
+
+// attachSession resumes the session of token or starts a new one
+func (gate *ServerGate) attachSession(conn network.Conn, token uint64, ack uint32) *session {
+	if token != 0 {
+		gate.mutexAgents.Lock()
+		s := gate.sessions[token]
+		gate.mutexAgents.Unlock()
+
+		if s != nil && s.attach(conn, ack) {
+			log.DebugF("session %x resumed from %v", token, conn.RemoteAddr())
+			return s
+		}
+		if s != nil {
+			log.DebugF("session %x not resumed: messages lost", token)
+			s.Destroy()
+		}
+	}
+
+	s := gate.newSession()
+	s.attach(conn, 0)
+	agentsGauge.Inc()
+	if gate.AgentChanRPC != nil {
+		gate.AgentChanRPC.Go(gate.NewAgentName, s.agent)
+	}
+	return s
+}
+
+// attach replaces the transport, fails if the client missed the messages
+// dropped from pending
+func (s *session) attach(conn network.Conn, ack uint32) bool {
+	s.Lock()
+	if s.ended || s.closing {
+		s.Unlock()
+		return false
+	}
+	first := s.sendSeq - uint32(len(s.pending)) + 1
+	if seqNewer(first, ack+1) || seqNewer(ack, s.sendSeq) {
+		s.Unlock()
+		return false
+	}
+
+	old := s.conn
+	s.conn = conn
+	s.localAddr = conn.LocalAddr()
+	s.remoteAddr = conn.RemoteAddr()
+	if s.grace != nil {
+		s.grace.Stop()
+		s.grace = nil
+	}
+	s.doAck(ack)
+	conn.WriteMsg(sessionHelloFrame(s.token, s.recvSeq))
+	for _, frame := range s.pending {
+		conn.WriteMsg(frame...)
+	}
+	s.Unlock()
+
+	// the client is back before the old transport noticed the drop
+	if old != nil {
+		old.Destroy()
+	}
+	return true
+}
+
+// detach starts the grace period, unless conn was replaced already
+func (s *session) detach(conn network.Conn) {
+	s.Lock()
+	if s.conn != conn || s.ended {
+		s.Unlock()
+		return
+	}
+	s.conn = nil
+
+	s.gate.mutexAgents.Lock()
+	closing := s.gate.closing
+	s.gate.mutexAgents.Unlock()
+	if !s.closing && !closing {
+		s.detaches++
+		detaches := s.detaches
+		s.grace = time.AfterFunc(s.gate.ResumeGrace, func() {
+			s.expire(detaches)
+		})
+		s.Unlock()
+		return
+	}
+	s.Unlock()
+
+	s.end()
+}
+
+func (s *session) expire(detaches int) {
+	s.Lock()
+	expired := s.detaches == detaches && s.conn == nil
+	s.Unlock()
+
+	if expired {
+		log.DebugF("session %x expired", s.token)
+		s.end()
+	}
+}
+
+// end is the real end of the session, it fires CloseAgent
+func (s *session) end() {
+	s.Lock()
+	if s.ended {
+		s.Unlock()
+		return
+	}
+	s.ended = true
+	if s.grace != nil {
+		s.grace.Stop()
+		s.grace = nil
+	}
+	s.pending = nil
+	s.Unlock()
+
+	s.gate.mutexAgents.Lock()
+	delete(s.gate.sessions, s.token)
+	s.gate.mutexAgents.Unlock()
+
+	s.agent.OnClose()
+}
+
+func (s *session) doAck(ack uint32) {
+	first := s.sendSeq - uint32(len(s.pending)) + 1
+	n := int(int32(ack-first)) + 1
+	if n <= 0 {
+		return
+	}
+	if n > len(s.pending) {
+		n = len(s.pending)
+	}
+	for i := 0; i < n; i++ {
+		s.pending[i] = nil
+	}
+	s.pending = s.pending[n:]
+}
+
+// input handles a frame of the transport after the hello
+func (s *session) input(data []byte) error {
+	if len(data) < 1 {
+		return errSessionFrame
+	}
+	switch data[0] {
+	case sessionData:
+		if len(data) < sessionDataLen {
+			return errSessionFrame
+		}
+		seq := binary.LittleEndian.Uint32(data[1:])
+		s.Lock()
+		dup := !seqNewer(seq, s.recvSeq)
+		if !dup {
+			s.recvSeq = seq
+		}
+		s.Unlock()
+		if dup {
+			return nil
+		}
+		return s.agent.route(data[sessionDataLen:])
+	case sessionAck:
+		if len(data) < sessionAckLen {
+			return errSessionFrame
+		}
+		s.Lock()
+		s.doAck(binary.LittleEndian.Uint32(data[1:]))
+		s.Unlock()
+		return nil
+	default:
+		return errSessionFrame
+	}
+}
+
+// the agent reads the transports, not the session
+func (s *session) ReadMsg() ([]byte, error) {
+	return nil, errors.New("session: read the transport")
+}
+
+// goroutine safe
+func (s *session) WriteMsg(args ...[]byte) error {
+	s.Lock()
+	defer s.Unlock()
+	if s.ended || s.closing {
+		return nil
+	}
+
+	s.sendSeq++
+	header := make([]byte, sessionDataLen)
+	header[0] = sessionData
+	binary.LittleEndian.PutUint32(header[1:], s.sendSeq)
+	frame := append([][]byte{header}, args...)
+	if s.conn != nil {
+		// on ErrWriteFull the transport is gone, the frame is sent again
+		// when the client resumes
+		if err := s.conn.WriteMsg(frame...); err != nil && err != network.ErrWriteFull {
+			s.sendSeq--
+			return err
+		}
+	}
+
+	s.pending = append(s.pending, frame)
+	if len(s.pending) > s.gate.ResumeBufferLen {
+		// the client cannot resume from before it now
+		s.pending[0] = nil
+		s.pending = s.pending[1:]
+	}
+	return nil
+}
+
+func (s *session) LocalAddr() net.Addr {
+	s.Lock()
+	defer s.Unlock()
+	return s.localAddr
+}
+
+// RemoteAddr is the address of the last transport
+func (s *session) RemoteAddr() net.Addr {
+	s.Lock()
+	defer s.Unlock()
+	return s.remoteAddr
+}
+
+// Close ends the session, the pending messages of the transport are sent
+func (s *session) Close() {
+	s.Lock()
+	s.closing = true
+	conn := s.conn
+	s.Unlock()
+
+	if conn != nil {
+		conn.Close()
+	} else {
+		s.end()
+	}
+}
+
+// Destroy ends the session at once
+func (s *session) Destroy() {
+	s.Lock()
+	s.closing = true
+	conn := s.conn
+	s.Unlock()
+
+	if conn != nil {
+		conn.Destroy()
+	} else {
+		s.end()
+	}
+}
+
+func (s *session) Read(p []byte) (int, error) {
+	return 0, errors.New("session: read the transport")
+}
+
+func (s *session) Write(p []byte) {
+	s.WriteMsg(p)
+}
+
+// transport is the agent of one connection of a resumable session
+type transport struct {
+	gate    *ServerGate
+	conn    network.Conn
+	session *session
+}
+
+func (t *transport) Run() {
+	data, err := t.conn.ReadMsg()
+	if err != nil {
+		log.Debug("read message: %v", err)
+		return
+	}
+	if len(data) != sessionHelloLen || data[0] != sessionHello {
+		log.DebugF("invalid session hello from %v", t.conn.RemoteAddr())
+		msgErrors("hello").Inc()
+		return
+	}
+	token := binary.LittleEndian.Uint64(data[1:])
+	ack := binary.LittleEndian.Uint32(data[9:])
+	t.session = t.gate.attachSession(t.conn, token, ack)
+
+	for {
+		data, err := t.conn.ReadMsg()
+		if err != nil {
+			log.Debug("read message: %v", err)
+			break
+		}
+		if err := t.session.input(data); err != nil {
+			// a bad client, the session ends with the transport
+			log.DebugF("session %x: %v", t.session.token, err)
+			t.session.Lock()
+			t.session.closing = true
+			t.session.Unlock()
+			break
+		}
+	}
+}
+
+func (t *transport) OnClose() {
+	if t.session != nil {
+		t.session.detach(t.conn)
+	}
+}
@@ -0,0 +1,188 @@
+package gate
+
+import (
+	"encoding/binary"
+	"gitee.com/aarlin/leaflet/chanrpc"
+	"gitee.com/aarlin/leaflet/network/json"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+type Echo struct {
+	Text string
+}
+
+// pipeConn is a transport driven by the test
+type pipeConn struct {
+	in        chan []byte
+	out       chan []byte
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func newPipeConn() *pipeConn {
+	return &pipeConn{in: make(chan []byte, 16), out: make(chan []byte, 16), done: make(chan struct{})}
+}
+
+func (c *pipeConn) ReadMsg() ([]byte, error) {
+	select {
+	case b := <-c.in:
+		return b, nil
+	case <-c.done:
+		return nil, io.EOF
+	}
+}
+
+func (c *pipeConn) WriteMsg(args ...[]byte) error {
+	var b []byte
+	for _, arg := range args {
+		b = append(b, arg...)
+	}
+	select {
+	case c.out <- b:
+	case <-c.done:
+	}
+	return nil
+}
+
+func (c *pipeConn) LocalAddr() net.Addr        { return nil }
+func (c *pipeConn) RemoteAddr() net.Addr       { return nil }
+func (c *pipeConn) Close()                     { c.closeOnce.Do(func() { close(c.done) }) }
+func (c *pipeConn) Destroy()                   { c.Close() }
+func (c *pipeConn) Read(p []byte) (int, error) { return 0, io.EOF }
+func (c *pipeConn) Write(p []byte)             { c.WriteMsg(p) }
+
+// dial runs a transport like the network servers do
+func dial(gate *ServerGate, token uint64, ack uint32) *pipeConn {
+	conn := newPipeConn()
+	conn.in <- sessionHelloFrame(token, ack)
+	a := gate.newAgent(conn)
+	go func() {
+		a.Run()
+		conn.Close()
+		a.OnClose()
+	}()
+	return conn
+}
+
+func recvFrame(t *testing.T, conn *pipeConn) []byte {
+	select {
+	case b := <-conn.out:
+		return b
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout")
+		return nil
+	}
+}
+
+func dataFrame(seq uint32, msg []byte) []byte {
+	b := make([]byte, sessionDataLen, sessionDataLen+len(msg))
+	b[0] = sessionData
+	binary.LittleEndian.PutUint32(b[1:], seq)
+	return append(b, msg...)
+}
+
+func TestSessionResume(t *testing.T) {
+	events := make(chan string, 16)
+	rpc := chanrpc.NewServer(16)
+	rpc.Register("NewAgent", func(args []interface{}) {
+		events <- "new"
+	})
+	rpc.Register("CloseAgent", func(args []interface{}) {
+		events <- "close"
+	})
+	go func() {
+		for ci := range rpc.ChanCall {
+			rpc.Exec(ci)
+		}
+	}()
+	defer rpc.Close()
+
+	processor := json.NewProcessor()
+	processor.Register(&Echo{})
+	processor.SetHandler(&Echo{}, func(args []interface{}) {
+		args[1].(Agent).WriteMsg(args[0])
+	})
+
+	gate := &ServerGate{
+		Processor:       processor,
+		AgentChanRPC:    rpc,
+		ResumeGrace:     time.Hour,
+		ResumeBufferLen: 8,
+	}
+	gate.init()
+
+	expect := func(want string) {
+		select {
+		case got := <-events:
+			if got != want {
+				t.Fatalf("got %v, want %v", got, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timeout waiting for %v", want)
+		}
+	}
+
+	conn := dial(gate, 0, 0)
+	hello := recvFrame(t, conn)
+	token := binary.LittleEndian.Uint64(hello[1:])
+	expect("new")
+
+	msg, _ := processor.Marshal(&Echo{"a"})
+	conn.in <- dataFrame(1, msg[0])
+	if b := recvFrame(t, conn); binary.LittleEndian.Uint32(b[1:]) != 1 {
+		t.Fatalf("bad echo %q", b)
+	}
+
+	// the transport drops, the echo of the last message is lost
+	conn.in <- dataFrame(2, msg[0])
+	recvFrame(t, conn)
+	conn.Close()
+
+	var a Agent
+	gate.mutexAgents.Lock()
+	for ag := range gate.agents {
+		a = ag
+	}
+	gate.mutexAgents.Unlock()
+	a.SetUserData("player")
+
+	// resumes with the ack of the first echo, the second is sent again
+	conn = dial(gate, token, 1)
+	hello = recvFrame(t, conn)
+	if binary.LittleEndian.Uint64(hello[1:]) != token || binary.LittleEndian.Uint32(hello[9:]) != 2 {
+		t.Fatalf("bad hello %q", hello)
+	}
+	if b := recvFrame(t, conn); binary.LittleEndian.Uint32(b[1:]) != 2 {
+		t.Fatalf("bad replay %q", b)
+	}
+
+	// the duplicates of the client are dropped
+	conn.in <- dataFrame(2, msg[0])
+	conn.in <- dataFrame(3, msg[0])
+	if b := recvFrame(t, conn); binary.LittleEndian.Uint32(b[1:]) != 3 {
+		t.Fatalf("bad echo %q", b)
+	}
+	if a.UserData() != "player" {
+		t.Fatal("user data lost")
+	}
+
+	// a wrong token is a new session
+	other := dial(gate, token+1, 0)
+	if b := recvFrame(t, other); binary.LittleEndian.Uint64(b[1:]) == token+1 {
+		t.Fatal("unknown token resumed")
+	}
+	expect("new")
+
+	// Close is the real end
+	a.Close()
+	expect("close")
+
+	// the grace period
+	gate.ResumeGrace = 10 * time.Millisecond
+	other.Close()
+	expect("close")
+}
@@ -1,8 +1,11 @@
 package g_test
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"gitee.com/aarlin/leaflet/go"
+	"sync"
 	"time"
 )
 
@@ -68,3 +71,92 @@ func ExampleLinearContext() {
 	// 1
 	// 2
 }
+
+func ExampleGo_GoCtx() {
+	// 1 worker, 1 waiting call
+	d := g.NewPool(10, 1, 1)
+
+	release := make(chan bool)
+	d.GoCtx(context.Background(), func(ctx context.Context) error {
+		<-release
+		return errors.New("db timeout")
+	}, func(err error) {
+		fmt.Println("load:", err)
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	d.GoCtx(ctx, func(ctx context.Context) error {
+		fmt.Println("will not print")
+		return nil
+	}, func(err error) {
+		fmt.Println("save:", err)
+	})
+
+	d.GoCtx(context.Background(), func(ctx context.Context) error {
+		fmt.Println("will not print")
+		return nil
+	}, func(err error) {
+		fmt.Println("rank:", err)
+	})
+	fmt.Println(d.Go(func() {}, nil))
+
+	// the result of save is no longer needed
+	cancel()
+	close(release)
+	d.Cb(<-d.ChanCb)
+	d.Cb(<-d.ChanCb)
+
+	d.GoCtx(context.Background(), func(ctx context.Context) error {
+		panic("bug")
+	}, func(err error) {
+		if e, ok := err.(*g.PanicError); ok {
+			fmt.Println("panic:", e.Value)
+		}
+	})
+
+	d.Close()
+
+	// Output:
+	// rank: go: queue full
+	// go: queue full
+	// load: db timeout
+	// save: context canceled
+	// panic: bug
+}
+
+func ExampleKeyedLinear() {
+	d := g.New(10)
+	k := d.NewKeyedLinear(4)
+
+	// the calls of a player run in order
+	var mutex sync.Mutex
+	steps := make(map[int][]int)
+	for step := 1; step <= 3; step++ {
+		for player := 1; player <= 8; player++ {
+			step, player := step, player
+			k.Go(player, func() {
+				mutex.Lock()
+				steps[player] = append(steps[player], step)
+				mutex.Unlock()
+			}, nil)
+		}
+	}
+
+	k.GoCtx(context.Background(), "player:42", func(ctx context.Context) error {
+		return errors.New("not found")
+	}, func(err error) {
+		fmt.Println("load:", err)
+	})
+
+	// the calls queued still run
+	k.Close()
+	fmt.Println(k.Go(1, func() {}, nil))
+
+	d.Close()
+	fmt.Println(steps[1], steps[8])
+
+	// Output:
+	// go: keyed linear closed
+	// load: not found
+	// [1 2 3] [1 2 3]
+}
@@ -2,16 +2,90 @@ package g
 
 import (
 	"container/list"
+	"context"
+	"errors"
+	"fmt"
 	"gitee.com/aarlin/leaflet/conf"
 	"gitee.com/aarlin/leaflet/log"
 	"runtime"
 	"sync"
 )
 
+// the queue of the worker pool is full, f is not run
+var ErrQueueFull = errors.New("go: queue full")
+
+// PanicError is given to the callback when f panics
+type PanicError struct {
+	Value interface{}
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	if len(e.Stack) > 0 {
+		return fmt.Sprintf("%v: %s", e.Value, e.Stack)
+	}
+	return fmt.Sprint(e.Value)
+}
+
+func newPanicError(r interface{}) *PanicError {
+	e := &PanicError{Value: r}
+	if conf.LenStackBuf > 0 {
+		buf := make([]byte, conf.LenStackBuf)
+		l := runtime.Stack(buf, false)
+		e.Stack = buf[:l]
+	}
+	return e
+}
+
 // one Go per goroutine (goroutine not safe)
 type Go struct {
 	ChanCb    chan func()
 	pendingGo int
+	pool      *pool
+}
+
+// runs the jobs on up to maxWorkers goroutines, started on demand
+// and gone once the queue is empty
+type pool struct {
+	mutex      sync.Mutex
+	workers    int
+	maxWorkers int
+	queue      []func()
+	maxQueue   int
+}
+
+func (p *pool) submit(job func()) bool {
+	p.mutex.Lock()
+	if p.workers < p.maxWorkers {
+		p.workers++
+		p.mutex.Unlock()
+		go p.work(job)
+		return true
+	}
+	if len(p.queue) >= p.maxQueue {
+		p.mutex.Unlock()
+		return false
+	}
+	p.queue = append(p.queue, job)
+	p.mutex.Unlock()
+	return true
+}
+
+func (p *pool) work(job func()) {
+	for {
+		job()
+
+		p.mutex.Lock()
+		if len(p.queue) == 0 {
+			p.workers--
+			p.mutex.Unlock()
+			return
+		}
+		job = p.queue[0]
+		p.queue[0] = nil
+		p.queue = p.queue[1:]
+		p.mutex.Unlock()
+	}
 }
 
 type LinearGo struct {
@@ -32,10 +106,32 @@ func New(l int) *Go {
 	return g
 }
 
-func (g *Go) Go(f func(), cb func()) {
-	g.pendingGo++
+// NewPool returns a Go running f on at most maxWorkers goroutines,
+// up to maxQueue calls wait for a worker and the others are rejected
+func NewPool(l int, maxWorkers int, maxQueue int) *Go {
+	if maxWorkers <= 0 {
+		log.FatalF("invalid maxWorkers %v", maxWorkers)
+	}
+	if maxQueue < 0 {
+		maxQueue = 0
+	}
 
-	go func() {
+	g := New(l)
+	g.pool = &pool{maxWorkers: maxWorkers, maxQueue: maxQueue}
+	return g
+}
+
+func (g *Go) run(job func()) bool {
+	if g.pool == nil {
+		go job()
+		return true
+	}
+	return g.pool.submit(job)
+}
+
+// runs f, then sends cb to the goroutine of g
+func (g *Go) job(f func(), cb func()) func() {
+	return func() {
 		defer func() {
 			g.ChanCb <- cb
 			if r := recover(); r != nil {
@@ -50,7 +146,61 @@ func (g *Go) Go(f func(), cb func()) {
 		}()
 
 		f()
-	}()
+	}
+}
+
+// runs f with ctx, then sends cb with the error to the goroutine of g
+func (g *Go) ctxJob(ctx context.Context, f func(ctx context.Context) error, cb func(err error)) func() {
+	return func() {
+		var err error
+		defer func() {
+			if r := recover(); r != nil {
+				err = newPanicError(r)
+			}
+			g.ChanCb <- func() {
+				if cb != nil {
+					cb(err)
+				} else if err != nil {
+					log.Error("%v", err)
+				}
+			}
+		}()
+
+		if err = ctx.Err(); err != nil {
+			return
+		}
+		err = f(ctx)
+	}
+}
+
+// Go runs f on another goroutine, then cb on the goroutine of g.
+// Rejected by a full pool, neither f nor cb runs and Go returns
+// ErrQueueFull, see GoCtx
+func (g *Go) Go(f func(), cb func()) error {
+	g.pendingGo++
+
+	if !g.run(g.job(f, cb)) {
+		g.pendingGo--
+		return ErrQueueFull
+	}
+	return nil
+}
+
+// GoCtx runs f with ctx on another goroutine, then cb on the goroutine
+// of g with the error of f, a *PanicError if f panics, ctx.Err() if ctx
+// is done before f starts or ErrQueueFull, given before GoCtx returns
+func (g *Go) GoCtx(ctx context.Context, f func(ctx context.Context) error, cb func(err error)) {
+	g.pendingGo++
+
+	if !g.run(g.ctxJob(ctx, f, cb)) {
+		g.Cb(func() {
+			if cb != nil {
+				cb(ErrQueueFull)
+			} else {
+				log.ErrorF("%v", ErrQueueFull)
+			}
+		})
+	}
 }
 
 func (g *Go) Cb(cb func()) {
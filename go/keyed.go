@@ -0,0 +1,147 @@
+package g
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"gitee.com/aarlin/leaflet/log"
+	"hash/fnv"
+	"sync"
+)
+
+// the KeyedLinear is closed, f is not run
+var ErrClosed = errors.New("go: keyed linear closed")
+
+// KeyedLinear runs the calls of the same key in order and those of
+// different keys in parallel, the keys are hashed onto a fixed set of
+// lanes, each drained by its own goroutine
+type KeyedLinear struct {
+	g     *Go
+	lanes []*lane
+}
+
+type lane struct {
+	mutex  sync.Mutex
+	cond   *sync.Cond
+	jobs   *list.List
+	closed bool
+}
+
+// NewKeyedLinear returns an executor of workers lanes, use one for
+// all the players rather than a LinearContext per player. Close stops
+// its goroutines
+func (g *Go) NewKeyedLinear(workers int) *KeyedLinear {
+	if workers <= 0 {
+		log.FatalF("invalid workers %v", workers)
+	}
+
+	k := new(KeyedLinear)
+	k.g = g
+	k.lanes = make([]*lane, workers)
+	for i := range k.lanes {
+		l := &lane{jobs: list.New()}
+		l.cond = sync.NewCond(&l.mutex)
+		k.lanes[i] = l
+		go l.work()
+	}
+	return k
+}
+
+func (k *KeyedLinear) lane(key interface{}) *lane {
+	n := uint64(len(k.lanes))
+	switch key := key.(type) {
+	case int:
+		return k.lanes[uint64(key)%n]
+	case int32:
+		return k.lanes[uint64(key)%n]
+	case int64:
+		return k.lanes[uint64(key)%n]
+	case uint:
+		return k.lanes[uint64(key)%n]
+	case uint32:
+		return k.lanes[uint64(key)%n]
+	case uint64:
+		return k.lanes[key%n]
+	case string:
+		h := fnv.New64a()
+		h.Write([]byte(key))
+		return k.lanes[h.Sum64()%n]
+	default:
+		h := fnv.New64a()
+		fmt.Fprint(h, key)
+		return k.lanes[h.Sum64()%n]
+	}
+}
+
+func (l *lane) push(job func()) bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.closed {
+		return false
+	}
+
+	l.jobs.PushBack(job)
+	l.cond.Signal()
+	return true
+}
+
+// work runs the jobs of the lane in order, it exits once the lane is
+// closed and empty
+func (l *lane) work() {
+	for {
+		l.mutex.Lock()
+		for l.jobs.Len() == 0 && !l.closed {
+			l.cond.Wait()
+		}
+		e := l.jobs.Front()
+		if e == nil {
+			l.mutex.Unlock()
+			return
+		}
+		l.jobs.Remove(e)
+		l.mutex.Unlock()
+
+		e.Value.(func())()
+	}
+}
+
+// Go runs f after the previous calls of the key, then cb on the
+// goroutine of the Go. After Close neither f nor cb runs and Go
+// returns ErrClosed
+func (k *KeyedLinear) Go(key interface{}, f func(), cb func()) error {
+	k.g.pendingGo++
+
+	if !k.lane(key).push(k.g.job(f, cb)) {
+		k.g.pendingGo--
+		return ErrClosed
+	}
+	return nil
+}
+
+// GoCtx runs f after the previous calls of the key, see Go.GoCtx. After
+// Close cb gets ErrClosed
+func (k *KeyedLinear) GoCtx(ctx context.Context, key interface{}, f func(ctx context.Context) error, cb func(err error)) {
+	k.g.pendingGo++
+
+	if !k.lane(key).push(k.g.ctxJob(ctx, f, cb)) {
+		k.g.Cb(func() {
+			if cb != nil {
+				cb(ErrClosed)
+			} else {
+				log.ErrorF("%v", ErrClosed)
+			}
+		})
+	}
+}
+
+// Close lets the goroutines run the calls queued, then exit. The Go
+// is not closed
+func (k *KeyedLinear) Close() {
+	for _, l := range k.lanes {
+		l.mutex.Lock()
+		l.closed = true
+		l.cond.Signal()
+		l.mutex.Unlock()
+	}
+}
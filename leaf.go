@@ -6,10 +6,12 @@ import (
 	"gitee.com/aarlin/leaflet/conf"
 	"gitee.com/aarlin/leaflet/console"
 	"gitee.com/aarlin/leaflet/log"
+	"gitee.com/aarlin/leaflet/metrics"
 	"gitee.com/aarlin/leaflet/module"
 	"os"
 	"os/signal"
 	"runtime/debug"
+	"syscall"
 	"time"
 )
 
@@ -31,7 +33,9 @@ func Run(mods ...module.Module) {
 	for i := 0; i < len(mods); i++ {
 		module.Register(mods[i])
 	}
-	module.Init()
+	if err := module.Init(); err != nil {
+		log.FatalF("%v", err)
+	}
 
 	// cluster
 	cluster.Init()
@@ -39,14 +43,70 @@ func Run(mods ...module.Module) {
 	// console
 	console.Init()
 
+	// metrics
+	metrics.Init()
+
 	// close
 	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt, os.Kill)
+	signal.Notify(c, os.Interrupt, os.Kill, syscall.SIGTERM, syscall.SIGHUP)
 	sig := <-c
-	log.Release("Leaf closing down (signal: %v)", sig)
-	console.Destroy()
-	cluster.Destroy()
-	module.Destroy()
+	for sig == syscall.SIGHUP {
+		if err := conf.Reload(); err != nil {
+			log.ErrorF("reload config error: %v", err)
+		} else {
+			log.ReleaseF("config reloaded")
+		}
+		sig = <-c
+	}
+	log.ReleaseF("Leaf closing down (signal: %v)", sig)
+
+	stopAcceptTimeout, notifyTimeout, drainTimeout, destroyTimeout := conf.ShutdownTimeouts()
+	shutdownPhase("stop accept", stopAcceptTimeout, func(<-chan struct{}) {
+		module.StopAccept()
+	})
+	shutdownPhase("notify closing", notifyTimeout, func(<-chan struct{}) {
+		module.NotifyClosing()
+	})
+	shutdownPhase("drain", drainTimeout, drain)
+	shutdownPhase("destroy", destroyTimeout, func(<-chan struct{}) {
+		metrics.Destroy()
+		console.Destroy()
+		cluster.Destroy()
+		module.Destroy()
+	})
+}
+
+// runs f and stops waiting for it after the timeout, then closes stop
+// so that f may give up too
+func shutdownPhase(name string, timeout time.Duration, f func(stop <-chan struct{})) {
+	log.ReleaseF("shutdown phase %v", name)
+	start := time.Now()
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		f(stop)
+	}()
+
+	select {
+	case <-done:
+		log.ReleaseF("shutdown phase %v done in %v", name, time.Since(start))
+	case <-time.After(timeout):
+		close(stop)
+		log.ErrorF("shutdown phase %v timed out after %v", name, timeout)
+	}
+}
+
+// waits for the modules to drain, until stop
+func drain(stop <-chan struct{}) {
+	for !module.Drained() {
+		select {
+		case <-stop:
+			return
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
 }
 
 func TryE() {
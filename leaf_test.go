@@ -0,0 +1,124 @@
+package leaf
+
+import (
+	"encoding/binary"
+	"gitee.com/aarlin/leaflet/gate"
+	"gitee.com/aarlin/leaflet/module"
+	"gitee.com/aarlin/leaflet/network"
+	"gitee.com/aarlin/leaflet/network/json"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+type Closing struct {
+	Reason string
+}
+
+type gateModule struct {
+	*gate.ServerGate
+}
+
+func (m gateModule) OnInit() {}
+
+func freeAddr(t *testing.T) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	return ln.Addr().String()
+}
+
+// readMsg reads a message of the default MsgParser
+func readMsg(conn net.Conn) ([]byte, error) {
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var head [2]byte
+	if _, err := io.ReadFull(conn, head[:]); err != nil {
+		return nil, err
+	}
+	msg := make([]byte, binary.BigEndian.Uint16(head[:]))
+	_, err := io.ReadFull(conn, msg)
+	return msg, err
+}
+
+func TestShutdownPhases(t *testing.T) {
+	processor := json.NewProcessor()
+	processor.Register(&Closing{})
+
+	addr := freeAddr(t)
+	module.Register(gateModule{&gate.ServerGate{
+		MaxConnNum:      10,
+		PendingWriteNum: 10,
+		Processor:       processor,
+		TCPAddr:         addr,
+		TcpParser:       network.NewMsgParser(),
+		ClosingMsg:      &Closing{Reason: "maintenance"},
+	}})
+	if err := module.Init(); err != nil {
+		t.Fatal(err)
+	}
+	defer module.Destroy()
+
+	// the gate listens once its Run has started
+	var conn net.Conn
+	var err error
+	for i := 0; i < 100; i++ {
+		if conn, err = net.Dial("tcp", addr); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// the agent is added by the accept loop
+	for i := 0; i < 100 && module.Drained(); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if module.Drained() {
+		t.Fatal("drained with a client connected")
+	}
+
+	shutdownPhase("stop accept", time.Second, func(<-chan struct{}) {
+		module.StopAccept()
+	})
+	if c, err := net.Dial("tcp", addr); err == nil {
+		c.Close()
+		t.Fatal("connect accepted after StopAccept")
+	}
+
+	shutdownPhase("notify closing", time.Second, func(<-chan struct{}) {
+		module.NotifyClosing()
+	})
+	msg, err := readMsg(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(msg), "maintenance") {
+		t.Fatalf("got %s, want ClosingMsg", msg)
+	}
+
+	// the drain gives up after its timeout while the client stays
+	stopped := make(chan struct{})
+	shutdownPhase("drain", 50*time.Millisecond, func(stop <-chan struct{}) {
+		drain(stop)
+		close(stopped)
+	})
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("drain still running after its timeout")
+	}
+
+	// done once the client leaves
+	conn.Close()
+	shutdownPhase("drain", 5*time.Second, drain)
+	if !module.Drained() {
+		t.Fatal("not drained")
+	}
+}
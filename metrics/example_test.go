@@ -0,0 +1,71 @@
+package metrics_test
+
+import (
+	"fmt"
+	"gitee.com/aarlin/leaflet/metrics"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+)
+
+func Example() {
+	logins := metrics.NewCounter("game_logins_total", "successful logins", "platform", "ios")
+	logins.Inc()
+	logins.Add(2)
+
+	online := metrics.NewGauge("game_online", "players online")
+	online.Set(10)
+	online.Dec()
+
+	queue := make(chan int, 8)
+	queue <- 1
+	metrics.NewGaugeFunc("game_queue_length", "", func() float64 {
+		return float64(len(queue))
+	})
+
+	latency := metrics.NewHistogram("game_save_seconds", "time spent saving a player",
+		[]float64{0.01, 0.1})
+	latency.Observe(0.005)
+	latency.Observe(0.05)
+	latency.Observe(1)
+
+	metrics.WriteText(os.Stdout, "game_")
+
+	// Output:
+	// # HELP game_logins_total successful logins
+	// # TYPE game_logins_total counter
+	// game_logins_total{platform="ios"} 3
+	// # HELP game_online players online
+	// # TYPE game_online gauge
+	// game_online 9
+	// # TYPE game_queue_length gauge
+	// game_queue_length 1
+	// # HELP game_save_seconds time spent saving a player
+	// # TYPE game_save_seconds histogram
+	// game_save_seconds_bucket{le="0.01"} 1
+	// game_save_seconds_bucket{le="0.1"} 2
+	// game_save_seconds_bucket{le="+Inf"} 3
+	// game_save_seconds_sum 1.055
+	// game_save_seconds_count 3
+}
+
+func ExampleHandler() {
+	metrics.NewCounter("http_example_total", "", "path", `/a"b`).Inc()
+
+	server := httptest.NewServer(metrics.Handler())
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/metrics?prefix=http_example")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	fmt.Print(string(body))
+
+	// Output:
+	// # TYPE http_example_total counter
+	// http_example_total{path="/a\"b"} 1
+}
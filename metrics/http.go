@@ -0,0 +1,42 @@
+package metrics
+
+import (
+	"gitee.com/aarlin/leaflet/conf"
+	"gitee.com/aarlin/leaflet/log"
+	"net"
+	"net/http"
+)
+
+var server *http.Server
+
+// Init serves the metrics on http://conf.MetricsAddr/metrics
+func Init() {
+	if conf.MetricsAddr == "" {
+		return
+	}
+
+	ln, err := net.Listen("tcp", conf.MetricsAddr)
+	if err != nil {
+		log.FatalF("%v", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", Handler())
+	server = &http.Server{Handler: mux}
+	go server.Serve(ln)
+}
+
+func Destroy() {
+	if server != nil {
+		server.Close()
+	}
+}
+
+// Handler writes the metrics in the Prometheus text format,
+// the optional query parameter prefix filters them by name
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		WriteText(w, r.URL.Query().Get("prefix"))
+	})
+}
@@ -0,0 +1,300 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// the upper bounds in seconds used by the histograms of the framework
+var DefBuckets = []float64{.0001, .0005, .001, .005, .01, .05, .1, .5, 1, 5}
+
+type metric interface {
+	write(w io.Writer, name string, labels string)
+}
+
+type family struct {
+	name   string
+	help   string
+	kind   string
+	series map[string]metric
+}
+
+var (
+	labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+
+	families      = make(map[string]*family)
+	mutexFamilies sync.Mutex
+)
+
+// labels are name, value pairs
+func formatLabels(labels []string) string {
+	if len(labels)%2 != 0 {
+		panic(fmt.Sprintf("labels %v: odd number of values", labels))
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(labels); i += 2 {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(labels[i])
+		b.WriteString(`="`)
+		b.WriteString(labelEscaper.Replace(labels[i+1]))
+		b.WriteByte('"')
+	}
+	return b.String()
+}
+
+// returns the series of the family, created by newMetric if needed
+func register(name string, help string, labels []string, kind string, newMetric func() metric) metric {
+	key := formatLabels(labels)
+
+	mutexFamilies.Lock()
+	defer mutexFamilies.Unlock()
+
+	f := families[name]
+	if f == nil {
+		f = &family{name: name, help: help, kind: kind, series: make(map[string]metric)}
+		families[name] = f
+	} else if f.kind != kind {
+		panic(fmt.Sprintf("metric %v: already registered as %v", name, f.kind))
+	}
+
+	m := f.series[key]
+	if m == nil {
+		m = newMetric()
+		f.series[key] = m
+	}
+	return m
+}
+
+// goroutine safe
+//
+// Unregister removes the series with the labels
+func Unregister(name string, labels ...string) {
+	key := formatLabels(labels)
+
+	mutexFamilies.Lock()
+	defer mutexFamilies.Unlock()
+
+	if f := families[name]; f != nil {
+		delete(f.series, key)
+		if len(f.series) == 0 {
+			delete(families, name)
+		}
+	}
+}
+
+// a value that only goes up
+type Counter struct {
+	v uint64
+}
+
+// goroutine safe
+//
+// NewCounter returns the counter of the name and labels, registered
+// at the first call
+func NewCounter(name string, help string, labels ...string) *Counter {
+	return register(name, help, labels, "counter", func() metric {
+		return new(Counter)
+	}).(*Counter)
+}
+
+func (c *Counter) Inc() {
+	atomic.AddUint64(&c.v, 1)
+}
+
+func (c *Counter) Add(n uint64) {
+	atomic.AddUint64(&c.v, n)
+}
+
+func (c *Counter) Value() uint64 {
+	return atomic.LoadUint64(&c.v)
+}
+
+func (c *Counter) write(w io.Writer, name string, labels string) {
+	writeSample(w, name, labels, float64(c.Value()))
+}
+
+// a value that goes up and down
+type Gauge struct {
+	bits uint64
+	f    func() float64
+}
+
+// goroutine safe
+func NewGauge(name string, help string, labels ...string) *Gauge {
+	return register(name, help, labels, "gauge", func() metric {
+		return new(Gauge)
+	}).(*Gauge)
+}
+
+// goroutine safe
+//
+// NewGaugeFunc registers a gauge whose value is f() at the time
+// of the export, f must goroutine safe
+func NewGaugeFunc(name string, help string, f func() float64, labels ...string) {
+	g := NewGauge(name, help, labels...)
+	mutexFamilies.Lock()
+	g.f = f
+	mutexFamilies.Unlock()
+}
+
+func (g *Gauge) Set(v float64) {
+	atomic.StoreUint64(&g.bits, math.Float64bits(v))
+}
+
+func (g *Gauge) Add(v float64) {
+	for {
+		old := atomic.LoadUint64(&g.bits)
+		if atomic.CompareAndSwapUint64(&g.bits, old, math.Float64bits(math.Float64frombits(old)+v)) {
+			return
+		}
+	}
+}
+
+func (g *Gauge) Inc() {
+	g.Add(1)
+}
+
+func (g *Gauge) Dec() {
+	g.Add(-1)
+}
+
+func (g *Gauge) Value() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&g.bits))
+}
+
+// the caller holds mutexFamilies
+func (g *Gauge) write(w io.Writer, name string, labels string) {
+	if g.f != nil {
+		writeSample(w, name, labels, g.f())
+	} else {
+		writeSample(w, name, labels, g.Value())
+	}
+}
+
+// counts the observed values in buckets
+type Histogram struct {
+	upperBounds []float64
+	counts      []uint64
+	count       uint64
+	sumBits     uint64
+}
+
+// goroutine safe
+//
+// NewHistogram returns the histogram of the name and labels, buckets
+// are the sorted upper bounds, DefBuckets if nil
+func NewHistogram(name string, help string, buckets []float64, labels ...string) *Histogram {
+	if buckets == nil {
+		buckets = DefBuckets
+	}
+	return register(name, help, labels, "histogram", func() metric {
+		h := new(Histogram)
+		h.upperBounds = buckets
+		h.counts = make([]uint64, len(buckets))
+		return h
+	}).(*Histogram)
+}
+
+func (h *Histogram) Observe(v float64) {
+	i := sort.SearchFloat64s(h.upperBounds, v)
+	if i < len(h.counts) {
+		atomic.AddUint64(&h.counts[i], 1)
+	}
+	for {
+		old := atomic.LoadUint64(&h.sumBits)
+		if atomic.CompareAndSwapUint64(&h.sumBits, old, math.Float64bits(math.Float64frombits(old)+v)) {
+			break
+		}
+	}
+	atomic.AddUint64(&h.count, 1)
+}
+
+func (h *Histogram) Count() uint64 {
+	return atomic.LoadUint64(&h.count)
+}
+
+func (h *Histogram) Sum() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&h.sumBits))
+}
+
+func (h *Histogram) write(w io.Writer, name string, labels string) {
+	sep := ""
+	if labels != "" {
+		sep = ","
+	}
+
+	var cumulative uint64
+	for i, bound := range h.upperBounds {
+		cumulative += atomic.LoadUint64(&h.counts[i])
+		le := strconv.FormatFloat(bound, 'g', -1, 64)
+		writeSample(w, name+"_bucket", labels+sep+`le="`+le+`"`, float64(cumulative))
+	}
+	writeSample(w, name+"_bucket", labels+sep+`le="+Inf"`, float64(h.Count()))
+	writeSample(w, name+"_sum", labels, h.Sum())
+	writeSample(w, name+"_count", labels, float64(h.Count()))
+}
+
+func writeSample(w io.Writer, name string, labels string, v float64) {
+	if labels != "" {
+		fmt.Fprintf(w, "%s{%s} %s\n", name, labels, formatValue(v))
+	} else {
+		fmt.Fprintf(w, "%s %s\n", name, formatValue(v))
+	}
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	default:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+}
+
+// goroutine safe
+//
+// WriteText writes the metrics whose name starts with prefix
+// in the Prometheus text format
+func WriteText(w io.Writer, prefix string) {
+	mutexFamilies.Lock()
+	defer mutexFamilies.Unlock()
+
+	names := make([]string, 0, len(families))
+	for name := range families {
+		if strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		f := families[name]
+		if f.help != "" {
+			fmt.Fprintf(w, "# HELP %s %s\n", name, helpEscaper.Replace(f.help))
+		}
+		fmt.Fprintf(w, "# TYPE %s %s\n", name, f.kind)
+
+		keys := make([]string, 0, len(f.series))
+		for key := range f.series {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			f.series[key].write(w, name, key)
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package module_test
+
+import (
+	"fmt"
+	"gitee.com/aarlin/leaflet/chanrpc"
+	"gitee.com/aarlin/leaflet/conf"
+	"gitee.com/aarlin/leaflet/module"
+	"gitee.com/aarlin/leaflet/timer"
+	"time"
+)
+
+type Mod struct {
+	name string
+	deps []string
+	fail bool
+}
+
+func (m *Mod) Supervision() module.Supervision {
+	if !m.fail {
+		return module.Supervision{}
+	}
+	return module.Supervision{
+		Restart:     module.RestartOnFailure,
+		MaxRestarts: 2,
+		Window:      time.Minute,
+		Backoff:     10 * time.Millisecond,
+	}
+}
+
+func (m *Mod) Name() string           { return m.name }
+func (m *Mod) Dependencies() []string { return m.deps }
+func (m *Mod) OnInit()                { fmt.Println("init", m.name) }
+func (m *Mod) OnDestroy()             { fmt.Println("destroy", m.name) }
+
+func (m *Mod) Run(closeSig chan bool) {
+	if m.fail {
+		panic("out of memory")
+	}
+	<-closeSig
+}
+
+func Example() {
+	conf.LenStackBuf = 0
+
+	events := chanrpc.NewServer(10)
+	events.Register("ModuleRestart", func(args []interface{}) {
+		fmt.Println("restart", args[0], args[1], args[2])
+	})
+	module.EventChanRPC = events
+
+	module.Register(&Mod{name: "gate", deps: []string{"game"}})
+	module.Register(&Mod{name: "game", deps: []string{"db"}})
+	module.Register(&Mod{name: "db"})
+	module.Register(&Mod{name: "stats", fail: true})
+
+	if err := module.Init(); err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	// stats panics in Run and is restarted twice
+	events.Exec(<-events.ChanCall)
+	events.Exec(<-events.ChanCall)
+	for {
+		if state, _ := module.StateOf("stats"); state == module.StateFailed {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	for _, s := range module.Statuses() {
+		fmt.Println(s.Name, s.State, s.Err, s.Restarts)
+	}
+
+	module.Destroy()
+	state, _ := module.StateOf("gate")
+	fmt.Println(state)
+
+	// Output:
+	// init db
+	// init game
+	// init gate
+	// init stats
+	// restart stats 1 out of memory
+	// restart stats 2 out of memory
+	// db running <nil> 0
+	// game running <nil> 0
+	// gate running <nil> 0
+	// stats failed out of memory 2
+	// destroy stats
+	// destroy gate
+	// destroy game
+	// destroy db
+	// stopped
+}
+
+func ExampleSkeleton_Schedule() {
+	server := chanrpc.NewServer(10)
+	s := &module.Skeleton{
+		Name:          "schedule",
+		AsynCallLen:   10,
+		ChanRPCServer: server,
+		Schedule: []module.SourceWeight{
+			{module.SourceAsynRet, 1},
+			{module.SourceChanRPC, 2},
+		},
+	}
+	s.Init()
+
+	done := make(chan bool)
+	s.RegisterChanRPC("msg", func(args []interface{}) {
+		fmt.Println("msg", args[0])
+		if args[0] == 4 {
+			done <- true
+		}
+	})
+
+	// queue the events before the skeleton runs
+	db := chanrpc.NewServer(10)
+	db.Register("load", func(args []interface{}) interface{} {
+		return args[0]
+	})
+	for i := 1; i <= 2; i++ {
+		s.AsynCall(db, "load", i, func(ret interface{}, err error) {
+			fmt.Println("loaded", ret)
+		})
+		db.Exec(<-db.ChanCall)
+	}
+	for i := 1; i <= 4; i++ {
+		server.Go("msg", i)
+	}
+
+	closeSig := make(chan bool, 1)
+	go s.Run(closeSig)
+	<-done
+	fmt.Println("waits", s.Waits(module.SourceChanRPC), s.Waits(module.SourceAsynRet))
+	closeSig <- true
+
+	// Output:
+	// loaded 1
+	// msg 1
+	// msg 2
+	// loaded 2
+	// msg 3
+	// msg 4
+	// waits 1 1
+}
+
+func ExampleSkeleton_AdvanceClock() {
+	clock := timer.NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	s := &module.Skeleton{
+		TimerDispatcherLen: 10,
+		Clock:              clock,
+	}
+	s.Init()
+	s.AfterFunc(time.Hour, func() {
+		fmt.Println("buff expired at", s.Now().Format("15:04"))
+	})
+
+	closeSig := make(chan bool, 1)
+	go s.Run(closeSig)
+	s.AdvanceClock(2 * time.Hour)
+	fmt.Println(clock.Now().Format("15:04"))
+	closeSig <- true
+
+	// Output:
+	// buff expired at 01:00
+	// 02:00
+}
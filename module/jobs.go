@@ -0,0 +1,53 @@
+package module
+
+import (
+	"bytes"
+	"fmt"
+	"gitee.com/aarlin/leaflet/timer"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// RegisterJobs registers the console command name to list the jobs of
+// the scheduler with their next fire time, "name run <job>" runs a job
+// by hand on the module goroutine
+func (s *Skeleton) RegisterJobs(name string, sched *timer.Scheduler) {
+	s.RegisterCommand(name, "list or run the cron jobs, usage: "+name+" [run <job>]",
+		func(args []interface{}) interface{} {
+			switch {
+			case len(args) == 0:
+				return formatJobs(sched.Jobs())
+			case len(args) == 2 && args[0] == "run":
+				job := args[1].(string)
+				if err := sched.RunJob(job); err != nil {
+					return err.Error()
+				}
+				return "job " + job + " done"
+			default:
+				return "usage: " + name + " [run <job>]"
+			}
+		})
+}
+
+func formatJobs(jobs []timer.JobInfo) string {
+	if len(jobs) == 0 {
+		return "no jobs"
+	}
+
+	format := func(t time.Time) string {
+		if t.IsZero() {
+			return "-"
+		}
+		return t.Format("2006-01-02 15:04:05 MST")
+	}
+
+	var buf bytes.Buffer
+	w := tabwriter.NewWriter(&buf, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tEXPR\tMISSED\tLAST\tNEXT")
+	for _, j := range jobs {
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\n", j.Name, j.Expr, j.Policy, format(j.Last), format(j.Next))
+	}
+	w.Flush()
+	return strings.Replace(strings.TrimSuffix(buf.String(), "\n"), "\n", "\r\n", -1)
+}
@@ -1,6 +1,7 @@
 package module
 
 import (
+	"fmt"
 	"gitee.com/aarlin/leaflet/conf"
 	"gitee.com/aarlin/leaflet/log"
 	"runtime"
@@ -13,60 +14,200 @@ type Module interface {
 	Run(closeSig chan bool)
 }
 
+// optional interfaces of a module
+
+// the name used by the dependencies and the state
+// a module without a name is named after its type
+type Namer interface {
+	Name() string
+}
+
+// the names of the modules which must be initialized before
+// and destroyed after the module
+type Dependent interface {
+	Dependencies() []string
+}
+
+// optional interfaces of a module, used by the phased shutdown
+
+// stops accepting new connections or work
+type AcceptStopper interface {
+	StopAccept()
+}
+
+// tells the connected clients that the server is closing
+type ClosingNotifier interface {
+	NotifyClosing()
+}
+
+// reports whether the pending work is done
+// must goroutine safe
+type Drainer interface {
+	Drained() bool
+}
+
 type module struct {
 	mi       Module
+	name     string
+	deps     []string
+	started  bool
+	closing  bool
+	state    State
+	err      error
+	restarts int
 	closeSig chan bool
 	wg       sync.WaitGroup
 }
 
-var mods []*module
+var (
+	mods       []*module
+	mutexState sync.Mutex
+)
 
 func Register(mi Module) {
 	m := new(module)
 	m.mi = mi
 	m.closeSig = make(chan bool, 1)
+	if n, ok := mi.(Namer); ok {
+		m.name = n.Name()
+	} else {
+		m.name = fmt.Sprintf("%T", mi)
+		for i := 2; lookup(m.name) != nil; i++ {
+			m.name = fmt.Sprintf("%T#%v", mi, i)
+		}
+	}
+	if d, ok := mi.(Dependent); ok {
+		m.deps = d.Dependencies()
+	}
+	m.state = StateRegistered
 
 	mods = append(mods, m)
 }
 
-func Init() {
+// Init sorts the modules by their dependencies, calls OnInit
+// and starts Run
+//
+// if a module fails in OnInit, the modules initialized before
+// are destroyed and the error names the failed module
+func Init() error {
+	sorted, err := sortModules(mods)
+	if err != nil {
+		return err
+	}
+	mutexState.Lock()
+	mods = sorted
+	mutexState.Unlock()
+
 	for i := 0; i < len(mods); i++ {
-		mods[i].mi.OnInit()
+		m := mods[i]
+		m.setState(StateInitializing, nil)
+		if err := safeCall(m.mi.OnInit); err != nil {
+			m.setState(StateFailed, err)
+			for j := i - 1; j >= 0; j-- {
+				destroy(mods[j])
+			}
+			return fmt.Errorf("module %v: OnInit: %v", m.name, err)
+		}
 	}
 
 	for i := 0; i < len(mods); i++ {
 		m := mods[i]
+		m.started = true
+		m.setState(StateRunning, nil)
 		m.wg.Add(1)
 		go run(m)
 	}
+	return nil
 }
 
 func Destroy() {
 	for i := len(mods) - 1; i >= 0; i-- {
 		m := mods[i]
+		if !m.started {
+			continue
+		}
+		mutexState.Lock()
+		m.closing = true
+		mutexState.Unlock()
 		m.closeSig <- true
 		m.wg.Wait()
 		destroy(m)
 	}
 }
 
+func StopAccept() {
+	for i := len(mods) - 1; i >= 0; i-- {
+		if mi, ok := mods[i].mi.(AcceptStopper); ok {
+			if err := safeCall(mi.StopAccept); err != nil {
+				log.ErrorF("module %v: StopAccept: %v", mods[i].name, err)
+			}
+		}
+	}
+}
+
+func NotifyClosing() {
+	for i := len(mods) - 1; i >= 0; i-- {
+		if mi, ok := mods[i].mi.(ClosingNotifier); ok {
+			if err := safeCall(mi.NotifyClosing); err != nil {
+				log.ErrorF("module %v: NotifyClosing: %v", mods[i].name, err)
+			}
+		}
+	}
+}
+
+// goroutine safe
+func Drained() bool {
+	for i := 0; i < len(mods); i++ {
+		if mi, ok := mods[i].mi.(Drainer); ok && !mi.Drained() {
+			return false
+		}
+	}
+	return true
+}
+
 func run(m *module) {
-	m.mi.Run(m.closeSig)
-	m.wg.Done()
+	defer m.wg.Done()
+
+	s := newSupervisor(m)
+	for {
+		err := safeCall(func() {
+			m.mi.Run(m.closeSig)
+		})
+		if !s.restart(err) {
+			return
+		}
+	}
 }
 
 func destroy(m *module) {
+	if m.State() != StateFailed {
+		m.setState(StateStopping, nil)
+	}
+	if err := safeCall(m.mi.OnDestroy); err != nil {
+		m.setState(StateFailed, err)
+		log.ErrorF("module %v: OnDestroy: %v", m.name, err)
+		return
+	}
+	if m.State() != StateFailed {
+		m.setState(StateStopped, nil)
+	}
+}
+
+// recovers the panic of f as an error, with the stack
+// if conf.LenStackBuf is set
+func safeCall(f func()) (err error) {
 	defer func() {
 		if r := recover(); r != nil {
 			if conf.LenStackBuf > 0 {
 				buf := make([]byte, conf.LenStackBuf)
 				l := runtime.Stack(buf, false)
-				log.Error("%v: %s", r, buf[:l])
+				err = fmt.Errorf("%v: %s", r, buf[:l])
 			} else {
-				log.Error("%v", r)
+				err = fmt.Errorf("%v", r)
 			}
 		}
 	}()
 
-	m.mi.OnDestroy()
+	f()
+	return
 }
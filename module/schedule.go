@@ -0,0 +1,188 @@
+package module
+
+import (
+	"fmt"
+	"gitee.com/aarlin/leaflet/log"
+	"gitee.com/aarlin/leaflet/metrics"
+)
+
+// an event source of the skeleton
+type Source int
+
+const (
+	SourceChanRPC Source = iota
+	SourceCommand
+	SourceAsynRet
+	SourceGo
+	SourceTimer
+	numSources
+)
+
+func (src Source) String() string {
+	switch src {
+	case SourceChanRPC:
+		return "chanrpc"
+	case SourceCommand:
+		return "command"
+	case SourceAsynRet:
+		return "asynret"
+	case SourceGo:
+		return "go"
+	case SourceTimer:
+		return "timer"
+	default:
+		return fmt.Sprintf("Source(%d)", int(src))
+	}
+}
+
+// Weight is the most events of the source handled in a round
+type SourceWeight struct {
+	Source Source
+	Weight int
+}
+
+func (s *Skeleton) initSchedule() {
+	if s.Schedule == nil {
+		return
+	}
+	if s.RoundBudget < 0 {
+		s.RoundBudget = 0
+		log.ReleaseF("invalid RoundBudget, reset to %v", s.RoundBudget)
+	}
+
+	var listed [numSources]bool
+	s.schedule = s.schedule[:0]
+	for _, sw := range s.Schedule {
+		if sw.Source < 0 || sw.Source >= numSources {
+			panic(fmt.Sprintf("invalid source %v", sw.Source))
+		}
+		if listed[sw.Source] {
+			panic(fmt.Sprintf("source %v: already scheduled", sw.Source))
+		}
+		listed[sw.Source] = true
+		if sw.Weight <= 0 {
+			sw.Weight = 1
+			log.ReleaseF("invalid weight of source %v, reset to %v", sw.Source, sw.Weight)
+		}
+		s.schedule = append(s.schedule, sw)
+	}
+	for src := Source(0); src < numSources; src++ {
+		if !listed[src] {
+			s.schedule = append(s.schedule, SourceWeight{src, 1})
+		}
+	}
+
+	for src := Source(0); src < numSources; src++ {
+		s.waits[src] = metrics.NewCounter("skeleton_source_waits_total",
+			"rounds a source ended with events left", "skeleton", s.Name, "source", src.String())
+	}
+}
+
+// Waits returns how many rounds the source ended with events left,
+// always 0 without a Schedule
+//
+// goroutine safe
+func (s *Skeleton) Waits(src Source) uint64 {
+	if src < 0 || src >= numSources || s.waits[src] == nil {
+		return 0
+	}
+	return s.waits[src].Value()
+}
+
+func (s *Skeleton) pending(src Source) int {
+	switch src {
+	case SourceChanRPC:
+		return len(s.server.ChanCall)
+	case SourceCommand:
+		return len(s.commandServer.ChanCall)
+	case SourceAsynRet:
+		return len(s.client.ChanAsynRet)
+	case SourceGo:
+		return len(s.g.ChanCb)
+	case SourceTimer:
+		return len(s.dispatcher.ChanTimer)
+	}
+	panic("bug")
+}
+
+// handles one event of the source if any, without blocking
+func (s *Skeleton) poll(src Source) bool {
+	switch src {
+	case SourceChanRPC:
+		select {
+		case ci := <-s.server.ChanCall:
+			s.server.Exec(ci)
+			return true
+		default:
+		}
+	case SourceCommand:
+		select {
+		case ci := <-s.commandServer.ChanCall:
+			s.commandServer.Exec(ci)
+			return true
+		default:
+		}
+	case SourceAsynRet:
+		select {
+		case ri := <-s.client.ChanAsynRet:
+			s.client.Cb(ri)
+			return true
+		default:
+		}
+	case SourceGo:
+		select {
+		case cb := <-s.g.ChanCb:
+			s.g.Cb(cb)
+			return true
+		default:
+		}
+	case SourceTimer:
+		select {
+		case t := <-s.dispatcher.ChanTimer:
+			t.Cb()
+			return true
+		default:
+		}
+	}
+	return false
+}
+
+// every round serves the sources in the order of the schedule, each
+// up to its weight and all together up to RoundBudget
+func (s *Skeleton) runScheduled(closeSig chan bool) {
+	for {
+		select {
+		case <-closeSig:
+			s.close()
+			return
+		default:
+		}
+
+		handled := 0
+		for _, sw := range s.schedule {
+			for n := 0; n < sw.Weight; n++ {
+				if s.RoundBudget > 0 && handled >= s.RoundBudget {
+					break
+				}
+				if !s.poll(sw.Source) {
+					break
+				}
+				handled++
+			}
+		}
+
+		if handled > 0 {
+			for _, sw := range s.schedule {
+				if s.pending(sw.Source) > 0 {
+					s.waits[sw.Source].Inc()
+				}
+			}
+			continue
+		}
+
+		// nothing to do, wait for any source
+		if !s.wait(closeSig) {
+			return
+		}
+	}
+}
@@ -1,23 +1,51 @@
 package module
 
 import (
+	"context"
 	"gitee.com/aarlin/leaflet/chanrpc"
+	"gitee.com/aarlin/leaflet/conf"
 	"gitee.com/aarlin/leaflet/console"
 	"gitee.com/aarlin/leaflet/go"
+	"gitee.com/aarlin/leaflet/log"
+	"gitee.com/aarlin/leaflet/metrics"
 	"gitee.com/aarlin/leaflet/timer"
+	"strconv"
+	"sync/atomic"
 	"time"
 )
 
+var skeletonNum int32
+
 type Skeleton struct {
-	GoLen              int
+	// labels the metrics of the skeleton
+	Name  string
+	GoLen int
+	// runs the Go calls on at most GoMaxWorkers goroutines if set, up to
+	// GoMaxQueue calls wait for a worker and the others are rejected
+	GoMaxWorkers       int
+	GoMaxQueue         int
 	TimerDispatcherLen int
-	AsynCallLen        int
-	ChanRPCServer      *chanrpc.Server
-	g                  *g.Go
-	dispatcher         *timer.Dispatcher
-	client             *chanrpc.Client
-	server             *chanrpc.Server
-	commandServer      *chanrpc.Server
+	// the timers share a timing wheel of the resolution if set
+	TimerWheelResolution time.Duration
+	// the clock of the timers, timer.SystemClock by default
+	Clock         timer.Clock
+	AsynCallLen   int
+	ChanRPCServer *chanrpc.Server
+
+	// the sources in priority order with their weights, the sources
+	// left out come last with weight 1. nil handles the events in
+	// the order they come
+	Schedule []SourceWeight
+	// the most events handled in a round, 0 means the sum of the weights
+	RoundBudget int
+
+	schedule      []SourceWeight
+	waits         [numSources]*metrics.Counter
+	g             *g.Go
+	dispatcher    *timer.Dispatcher
+	client        *chanrpc.Client
+	server        *chanrpc.Server
+	commandServer *chanrpc.Server
 }
 
 func (s *Skeleton) Init() {
@@ -31,8 +59,22 @@ func (s *Skeleton) Init() {
 		s.AsynCallLen = 0
 	}
 
-	s.g = g.New(s.GoLen)
-	s.dispatcher = timer.NewDispatcher(s.TimerDispatcherLen)
+	if s.GoMaxWorkers > 0 {
+		s.g = g.NewPool(s.GoLen, s.GoMaxWorkers, s.GoMaxQueue)
+	} else {
+		s.g = g.New(s.GoLen)
+	}
+	if s.Clock != nil && s.Clock != timer.SystemClock {
+		if s.TimerWheelResolution > 0 {
+			s.TimerWheelResolution = 0
+			log.ReleaseF("invalid TimerWheelResolution with a clock, reset to %v", s.TimerWheelResolution)
+		}
+		s.dispatcher = timer.NewDispatcherWithClock(s.TimerDispatcherLen, s.Clock)
+	} else if s.TimerWheelResolution > 0 {
+		s.dispatcher = timer.NewWheelDispatcher(s.TimerDispatcherLen, s.TimerWheelResolution)
+	} else {
+		s.dispatcher = timer.NewDispatcher(s.TimerDispatcherLen)
+	}
 	s.client = chanrpc.NewClient(s.AsynCallLen)
 	s.server = s.ChanRPCServer
 
@@ -40,31 +82,106 @@ func (s *Skeleton) Init() {
 		s.server = chanrpc.NewServer(0)
 	}
 	s.commandServer = chanrpc.NewServer(0)
+
+	if s.Name == "" {
+		s.Name = "skeleton" + strconv.Itoa(int(atomic.AddInt32(&skeletonNum, 1)))
+	}
+	if s.server.Name == "" {
+		s.server.Name = s.Name
+	}
+	s.commandServer.Name = s.Name + ".command"
+	s.commandServer.Register(advanceClock{}, func(args []interface{}) {
+		args[0].(*timer.FakeClock).Advance(args[1].(time.Duration))
+	})
+	s.registerMetrics()
+	s.initSchedule()
+}
+
+func (s *Skeleton) registerMetrics() {
+	queue := func(name string, length func() int, capacity int) {
+		metrics.NewGaugeFunc("skeleton_queue_length", "messages waiting in a queue of the skeleton",
+			func() float64 { return float64(length()) },
+			"skeleton", s.Name, "queue", name)
+		metrics.NewGauge("skeleton_queue_capacity", "capacity of a queue of the skeleton",
+			"skeleton", s.Name, "queue", name).Set(float64(capacity))
+	}
+
+	server, commandServer := s.server, s.commandServer
+	client, gs, dispatcher := s.client, s.g, s.dispatcher
+	queue("chanrpc", func() int { return len(server.ChanCall) }, cap(server.ChanCall))
+	queue("command", func() int { return len(commandServer.ChanCall) }, cap(commandServer.ChanCall))
+	queue("asynret", func() int { return len(client.ChanAsynRet) }, cap(client.ChanAsynRet))
+	queue("go", func() int { return len(gs.ChanCb) }, cap(gs.ChanCb))
+	queue("timer", func() int { return len(dispatcher.ChanTimer) }, cap(dispatcher.ChanTimer))
 }
 
 func (s *Skeleton) Run(closeSig chan bool) {
-	for {
-		select {
-		case <-closeSig:
-			s.commandServer.Close()
-			s.server.Close()
-			for !s.g.Idle() || !s.client.Idle() {
-				s.g.Close()
-				s.client.Close()
-			}
-			return
-		case ri := <-s.client.ChanAsynRet:
-			s.client.Cb(ri)
-		case ci := <-s.server.ChanCall:
-			s.server.Exec(ci)
-		case ci := <-s.commandServer.ChanCall:
-			s.commandServer.Exec(ci)
-		case cb := <-s.g.ChanCb:
-			s.g.Cb(cb)
-		case t := <-s.dispatcher.ChanTimer:
-			t.Cb()
-		}
+	if s.schedule != nil {
+		s.runScheduled(closeSig)
+		return
+	}
+
+	for s.wait(closeSig) {
+	}
+}
+
+// handles the first event of any source, returns false once closed
+func (s *Skeleton) wait(closeSig chan bool) bool {
+	select {
+	case <-closeSig:
+		s.close()
+		return false
+	case ri := <-s.client.ChanAsynRet:
+		s.client.Cb(ri)
+	case ci := <-s.server.ChanCall:
+		s.server.Exec(ci)
+	case ci := <-s.commandServer.ChanCall:
+		s.commandServer.Exec(ci)
+	case cb := <-s.g.ChanCb:
+		s.g.Cb(cb)
+	case t := <-s.dispatcher.ChanTimer:
+		t.Cb()
+	}
+	return true
+}
+
+func (s *Skeleton) close() {
+	s.commandServer.Close()
+	s.server.Close()
+	for !s.g.Idle() || !s.client.Idle() {
+		s.g.Close()
+		s.client.Close()
+	}
+	s.dispatcher.Close()
+}
+
+// goroutine safe
+func (s *Skeleton) Drained() bool {
+	return len(s.server.ChanCall) == 0 &&
+		len(s.commandServer.ChanCall) == 0 &&
+		len(s.client.ChanAsynRet) == 0 &&
+		len(s.g.ChanCb) == 0 &&
+		len(s.dispatcher.ChanTimer) == 0
+}
+
+// Now returns the time of the clock of the module
+func (s *Skeleton) Now() time.Time {
+	return s.dispatcher.Clock().Now()
+}
+
+type advanceClock struct{}
+
+// AdvanceClock advances the timer.FakeClock of the module and fires
+// the due timers on the module goroutine, the module must be running
+//
+// goroutine safe
+func (s *Skeleton) AdvanceClock(d time.Duration) error {
+	clock, ok := s.Clock.(*timer.FakeClock)
+	if !ok {
+		panic("invalid Clock")
 	}
+
+	return s.commandServer.Call0(advanceClock{}, clock, d)
 }
 
 func (s *Skeleton) AfterFunc(d time.Duration, cb func()) *timer.Timer {
@@ -83,12 +200,60 @@ func (s *Skeleton) CronFunc(cronExpr *timer.CronExpr, cb func()) *timer.Cron {
 	return s.dispatcher.CronFunc(cronExpr, cb)
 }
 
-func (s *Skeleton) Go(f func(), cb func()) {
+// TimerGroup returns the group of the name, Stop cancels all its
+// timers, crons and tickers, for instance when a player logs out
+func (s *Skeleton) TimerGroup(name string) *timer.Group {
+	if s.TimerDispatcherLen == 0 {
+		panic("invalid TimerDispatcherLen")
+	}
+
+	return s.dispatcher.Group(name)
+}
+
+// Timers returns the active timers of the module, call it on the
+// module goroutine
+func (s *Skeleton) Timers() []timer.TimerInfo {
+	return s.dispatcher.Timers()
+}
+
+// NewScheduler returns a scheduler of cron jobs run on the module
+// goroutine, their last run times are kept in store
+func (s *Skeleton) NewScheduler(store timer.JobStore) (*timer.Scheduler, error) {
+	if s.TimerDispatcherLen == 0 {
+		panic("invalid TimerDispatcherLen")
+	}
+
+	return timer.NewScheduler(s.dispatcher, store)
+}
+
+// Tick runs cb on the module goroutine every interval,
+// see timer.Ticker for the policies and the statistics
+func (s *Skeleton) Tick(interval time.Duration, cb func(frame uint64, dt time.Duration)) *timer.Ticker {
+	if s.TimerDispatcherLen == 0 {
+		panic("invalid TimerDispatcherLen")
+	}
+
+	return s.dispatcher.TickFunc(interval, cb)
+}
+
+// Go returns g.ErrQueueFull when the calls waiting fill GoMaxQueue,
+// f and cb are not run
+func (s *Skeleton) Go(f func(), cb func()) error {
+	if s.GoLen == 0 {
+		panic("invalid GoLen")
+	}
+
+	return s.g.Go(f, cb)
+}
+
+// GoCtx runs f with ctx on another goroutine, then cb on the module
+// goroutine with the error or the panic of f, see g.Go.GoCtx
+func (s *Skeleton) GoCtx(ctx context.Context, f func(ctx context.Context) error, cb func(err error)) {
 	if s.GoLen == 0 {
 		panic("invalid GoLen")
 	}
 
-	s.g.Go(f, cb)
+	s.g.GoCtx(ctx, f, cb)
 }
 
 func (s *Skeleton) NewLinearContext() *g.LinearContext {
@@ -99,6 +264,17 @@ func (s *Skeleton) NewLinearContext() *g.LinearContext {
 	return s.g.NewLinearContext()
 }
 
+// NewKeyedLinear returns an executor running the calls of the same key,
+// such as a player ID, in order on one of workers goroutines. Close it
+// in OnDestroy
+func (s *Skeleton) NewKeyedLinear(workers int) *g.KeyedLinear {
+	if s.GoLen == 0 {
+		panic("invalid GoLen")
+	}
+
+	return s.g.NewKeyedLinear(workers)
+}
+
 func (s *Skeleton) AsynCall(server *chanrpc.Server, id interface{}, args ...interface{}) {
 	if s.AsynCallLen == 0 {
 		panic("invalid AsynCallLen")
@@ -116,6 +292,38 @@ func (s *Skeleton) RegisterChanRPC(id interface{}, f interface{}) {
 	s.server.Register(id, f)
 }
 
+type confReload struct {
+	name string
+}
+
+// RegisterConf binds the config section name to v, a pointer to a struct.
+// Reloads post the new values to the module goroutine, which applies them
+// then calls cb; Reload does not wait for the module
+func (s *Skeleton) RegisterConf(name string, v interface{}, cb func()) {
+	id := confReload{name}
+	// posted counts the reloads under the loader lock, a post overtaken
+	// by a newer one is stale
+	var posted, applied uint64
+	s.commandServer.Register(id, func(args []interface{}) {
+		if n := args[1].(uint64); n > applied {
+			applied = n
+			args[0].(func())()
+			if cb != nil {
+				cb()
+			}
+		}
+	})
+
+	err := conf.Register(name, v, func(apply func()) error {
+		posted++
+		go s.commandServer.Go(id, apply, posted)
+		return nil
+	})
+	if err != nil {
+		panic(err)
+	}
+}
+
 func (s *Skeleton) RegisterCommand(name string, help string, f interface{}) {
 	console.Register(name, help, f, s.commandServer)
 }
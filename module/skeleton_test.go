@@ -0,0 +1,115 @@
+package module
+
+import (
+	"fmt"
+	"gitee.com/aarlin/leaflet/conf"
+	"io/ioutil"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+type reloadConf struct {
+	MaxPlayers int
+}
+
+func goroutineID() string {
+	buf := make([]byte, 64)
+	buf = buf[:runtime.Stack(buf, false)]
+	return strings.Fields(string(buf))[1]
+}
+
+// the sections stay registered, each run of the test has its own
+var reloadRuns int
+
+func TestRegisterConf(t *testing.T) {
+	reloadRuns++
+	name := fmt.Sprintf("reload%v", reloadRuns)
+	file := filepath.Join(t.TempDir(), "server.json")
+	write := func(maxPlayers int) {
+		b := fmt.Sprintf(`{"%v": {"MaxPlayers": %v}}`, name, maxPlayers)
+		if err := ioutil.WriteFile(file, []byte(b), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(1)
+	if err := conf.Load(file); err != nil {
+		t.Fatal(err)
+	}
+
+	s := &Skeleton{GoLen: 10, TimerDispatcherLen: 10, AsynCallLen: 10}
+	s.Init()
+
+	type applied struct {
+		goroutine  string
+		maxPlayers int
+	}
+	c := new(reloadConf)
+	cbs := make(chan applied, 10)
+	s.RegisterConf(name, c, func() {
+		cbs <- applied{goroutineID(), c.MaxPlayers}
+	})
+	if c.MaxPlayers != 1 {
+		t.Fatalf("MaxPlayers %v, want 1", c.MaxPlayers)
+	}
+	next := func() applied {
+		select {
+		case a := <-cbs:
+			return a
+		case <-time.After(5 * time.Second):
+			t.Fatal("cb not called")
+			return applied{}
+		}
+	}
+
+	// Reload does not wait for the module, nothing runs before Run
+	write(2)
+	if err := conf.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-cbs:
+		t.Fatal("cb called off the module goroutine")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	closeSig := make(chan bool, 1)
+	running := make(chan string, 1)
+	done := make(chan struct{})
+	go func() {
+		running <- goroutineID()
+		s.Run(closeSig)
+		close(done)
+	}()
+	module := <-running
+
+	// applied on the module goroutine before cb
+	if a := next(); a.goroutine != module || a.maxPlayers != 2 {
+		t.Fatalf("cb on goroutine %v with MaxPlayers %v, want %v and 2", a.goroutine, a.maxPlayers, module)
+	}
+
+	// a post overtaken by a newer reload is dropped
+	s.commandServer.Go(confReload{name}, func() {
+		c.MaxPlayers = -1
+	}, uint64(1))
+	write(3)
+	if err := conf.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if a := next(); a.maxPlayers != 3 {
+		t.Fatalf("MaxPlayers %v, want 3", a.maxPlayers)
+	}
+
+	closeSig <- true
+	<-done
+	select {
+	case a := <-cbs:
+		t.Fatalf("stale post applied: %+v", a)
+	default:
+	}
+	if c.MaxPlayers != 3 {
+		t.Fatalf("MaxPlayers %v, want 3", c.MaxPlayers)
+	}
+}
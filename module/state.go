@@ -0,0 +1,171 @@
+package module
+
+import (
+	"fmt"
+	"strings"
+)
+
+// lifecycle state of a module
+type State int
+
+const (
+	StateRegistered State = iota
+	StateInitializing
+	StateRunning
+	StateStopping
+	StateStopped
+	StateFailed
+)
+
+func (s State) String() string {
+	switch s {
+	case StateRegistered:
+		return "registered"
+	case StateInitializing:
+		return "initializing"
+	case StateRunning:
+		return "running"
+	case StateStopping:
+		return "stopping"
+	case StateStopped:
+		return "stopped"
+	case StateFailed:
+		return "failed"
+	default:
+		return fmt.Sprintf("State(%d)", int(s))
+	}
+}
+
+type Status struct {
+	Name         string
+	Dependencies []string
+	State        State
+	// why the module failed last
+	Err      error
+	Restarts int
+}
+
+func (m *module) setState(state State, err error) {
+	mutexState.Lock()
+	m.state = state
+	if err != nil {
+		m.err = err
+	}
+	mutexState.Unlock()
+}
+
+func (m *module) isClosing() bool {
+	mutexState.Lock()
+	defer mutexState.Unlock()
+	return m.closing
+}
+
+func (m *module) State() State {
+	mutexState.Lock()
+	defer mutexState.Unlock()
+	return m.state
+}
+
+func lookup(name string) *module {
+	for _, m := range mods {
+		if m.name == name {
+			return m
+		}
+	}
+	return nil
+}
+
+// goroutine safe
+//
+// Statuses returns the modules in the initialization order
+// once Init is called, in the registration order before
+func Statuses() []Status {
+	mutexState.Lock()
+	defer mutexState.Unlock()
+
+	ss := make([]Status, len(mods))
+	for i, m := range mods {
+		ss[i] = Status{
+			Name:         m.name,
+			Dependencies: m.deps,
+			State:        m.state,
+			Err:          m.err,
+			Restarts:     m.restarts,
+		}
+	}
+	return ss
+}
+
+// goroutine safe
+func StateOf(name string) (State, error) {
+	mutexState.Lock()
+	defer mutexState.Unlock()
+
+	m := lookup(name)
+	if m == nil {
+		return 0, fmt.Errorf("module %v: not registered", name)
+	}
+	return m.state, m.err
+}
+
+// sortModules orders the modules so that every module comes after
+// its dependencies, the others keep the registration order
+func sortModules(mods []*module) ([]*module, error) {
+	index := make(map[string]*module, len(mods))
+	for _, m := range mods {
+		if index[m.name] != nil {
+			return nil, fmt.Errorf("module %v: already registered", m.name)
+		}
+		index[m.name] = m
+	}
+	for _, m := range mods {
+		for _, dep := range m.deps {
+			if index[dep] == nil {
+				return nil, fmt.Errorf("module %v: unknown dependency %v", m.name, dep)
+			}
+		}
+	}
+
+	const (
+		visiting = 1
+		visited  = 2
+	)
+	marks := make(map[*module]int, len(mods))
+	sorted := make([]*module, 0, len(mods))
+	var path []string
+
+	var visit func(m *module) error
+	visit = func(m *module) error {
+		switch marks[m] {
+		case visited:
+			return nil
+		case visiting:
+			for i, name := range path {
+				if name == m.name {
+					cycle := append(path[i:len(path):len(path)], m.name)
+					return fmt.Errorf("module dependency cycle: %v", strings.Join(cycle, " -> "))
+				}
+			}
+		}
+
+		marks[m] = visiting
+		path = append(path, m.name)
+		for _, dep := range m.deps {
+			if err := visit(index[dep]); err != nil {
+				return err
+			}
+		}
+		path = path[:len(path)-1]
+		marks[m] = visited
+
+		sorted = append(sorted, m)
+		return nil
+	}
+
+	for _, m := range mods {
+		if err := visit(m); err != nil {
+			return nil, err
+		}
+	}
+	return sorted, nil
+}
@@ -0,0 +1,169 @@
+package module
+
+import (
+	"gitee.com/aarlin/leaflet/chanrpc"
+	"gitee.com/aarlin/leaflet/log"
+	"time"
+)
+
+// ModuleRestart is sent to EventChanRPC with the module name,
+// the number of restarts and the error of Run (nil if Run returned)
+var EventChanRPC *chanrpc.Server
+
+type RestartPolicy int
+
+const (
+	RestartNever RestartPolicy = iota
+	// restarts when Run panics or returns before closing
+	RestartAlways
+	// restarts only when Run panics
+	RestartOnFailure
+)
+
+type Supervision struct {
+	Restart RestartPolicy
+	// at most MaxRestarts restarts within Window, 0 means no limit
+	MaxRestarts int
+	Window      time.Duration
+	// the wait before the first restart, doubled on every restart
+	// up to MaxBackoff, 1s by default when the module restarts
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// calls OnDestroy and OnInit before restarting Run
+	ReInit bool
+}
+
+// optional interface of a module, the module is never restarted
+// without it
+type Supervised interface {
+	Supervision() Supervision
+}
+
+func (sv *Supervision) init() {
+	if sv.MaxRestarts < 0 {
+		sv.MaxRestarts = 0
+	}
+	if sv.MaxRestarts > 0 && sv.Window <= 0 {
+		sv.Window = time.Minute
+	}
+	// a module failing at once would restart in a busy loop
+	if sv.Restart != RestartNever && sv.Backoff <= 0 {
+		sv.Backoff = time.Second
+		log.ReleaseF("invalid Backoff, reset to %v", sv.Backoff)
+	}
+	if sv.Backoff < 0 {
+		sv.Backoff = 0
+	}
+	if sv.MaxBackoff < sv.Backoff {
+		sv.MaxBackoff = sv.Backoff
+	}
+}
+
+func (sv *Supervision) restartable(err error) bool {
+	switch sv.Restart {
+	case RestartAlways:
+		return true
+	case RestartOnFailure:
+		return err != nil
+	default:
+		return false
+	}
+}
+
+type supervisor struct {
+	Supervision
+	m        *module
+	restarts []time.Time
+	backoff  time.Duration
+}
+
+func newSupervisor(m *module) *supervisor {
+	s := new(supervisor)
+	s.m = m
+	if mi, ok := m.mi.(Supervised); ok {
+		s.Supervision = mi.Supervision()
+	}
+	s.init()
+	s.backoff = s.Backoff
+	return s
+}
+
+// prepares the module to run again after Run stopped with err
+// returns false if the module must stay stopped
+func (s *supervisor) restart(err error) bool {
+	m := s.m
+	stage := "Run"
+	for {
+		if m.isClosing() {
+			if err != nil {
+				m.setState(StateFailed, err)
+			}
+			return false
+		}
+
+		if err != nil {
+			m.setState(StateFailed, err)
+			log.ErrorF("module %v: %v: %v", m.name, stage, err)
+		} else {
+			m.setState(StateStopped, nil)
+			log.ErrorF("module %v: Run returned before closing", m.name)
+		}
+
+		if !s.restartable(err) {
+			return false
+		}
+
+		now := time.Now()
+		if s.MaxRestarts > 0 {
+			i := 0
+			for i < len(s.restarts) && now.Sub(s.restarts[i]) > s.Window {
+				i++
+			}
+			s.restarts = s.restarts[i:]
+			if len(s.restarts) >= s.MaxRestarts {
+				log.ErrorF("module %v: %v restarts within %v, giving up", m.name, len(s.restarts), s.Window)
+				return false
+			}
+			s.restarts = append(s.restarts, now)
+		}
+
+		if s.backoff > 0 {
+			select {
+			case <-m.closeSig:
+				return false
+			case <-time.After(s.backoff):
+			}
+			s.backoff *= 2
+			if s.backoff > s.MaxBackoff {
+				s.backoff = s.MaxBackoff
+			}
+		}
+
+		if s.ReInit {
+			if e := safeCall(m.mi.OnDestroy); e != nil {
+				log.ErrorF("module %v: OnDestroy: %v", m.name, e)
+			}
+			if e := safeCall(m.mi.OnInit); e != nil {
+				err = e
+				stage = "OnInit"
+				continue
+			}
+		}
+
+		m.restarted(err)
+		return true
+	}
+}
+
+func (m *module) restarted(err error) {
+	mutexState.Lock()
+	m.restarts++
+	m.state = StateRunning
+	restarts := m.restarts
+	mutexState.Unlock()
+
+	log.ReleaseF("module %v: restarted (%v)", m.name, restarts)
+	if EventChanRPC != nil {
+		EventChanRPC.Go("ModuleRestart", m.name, restarts, err)
+	}
+}
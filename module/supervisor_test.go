@@ -0,0 +1,29 @@
+package module
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSupervisionBackoff(t *testing.T) {
+	for _, sv := range []Supervision{
+		{Restart: RestartAlways},
+		{Restart: RestartOnFailure, Backoff: -time.Second},
+	} {
+		sv.init()
+		if sv.Backoff != time.Second || sv.MaxBackoff != time.Second {
+			t.Errorf("policy %v: Backoff %v, MaxBackoff %v, want 1s", sv.Restart, sv.Backoff, sv.MaxBackoff)
+		}
+	}
+
+	sv := Supervision{Restart: RestartAlways, Backoff: 10 * time.Millisecond}
+	sv.init()
+	if sv.Backoff != 10*time.Millisecond {
+		t.Errorf("Backoff %v, want 10ms", sv.Backoff)
+	}
+	sv = Supervision{}
+	sv.init()
+	if sv.Backoff != 0 {
+		t.Errorf("Backoff %v without restarts, want 0", sv.Backoff)
+	}
+}
@@ -0,0 +1,325 @@
+package network
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"gitee.com/aarlin/leaflet/log"
+	"net"
+	"sync"
+	"time"
+)
+
+// The datagram channel is unreliable and unordered, it sits beside a
+// TCP or websocket session, which hands the token to the peer.
+//
+//	-------------------------------
+//	| token | seq | message       |
+//	-------------------------------
+//
+// token is 8 bytes, seq 4 bytes, both little endian. A datagram whose seq
+// is not newer than the last one received is stale and dropped. A datagram
+// without message tells the server the address of the peer.
+const datagramHeaderLen = 12
+
+var errDatagramUnbound = errors.New("datagram peer address unknown")
+
+// seq a is newer than b, with wraparound
+func seqNewer(a, b uint32) bool {
+	return int32(a-b) > 0
+}
+
+func putDatagramHeader(b []byte, token uint64, seq uint32) {
+	binary.LittleEndian.PutUint64(b, token)
+	binary.LittleEndian.PutUint32(b[8:], seq)
+}
+
+func datagramPacket(token uint64, seq uint32, maxMsgLen uint32, args [][]byte) ([]byte, error) {
+	var msgLen uint32
+	for i := 0; i < len(args); i++ {
+		msgLen += uint32(len(args[i]))
+	}
+	if msgLen > maxMsgLen {
+		return nil, errors.New("message too long")
+	} else if msgLen < 1 {
+		return nil, errors.New("message too short")
+	}
+
+	b := make([]byte, datagramHeaderLen, datagramHeaderLen+int(msgLen))
+	putDatagramHeader(b, token, seq)
+	for i := 0; i < len(args); i++ {
+		b = append(b, args[i]...)
+	}
+	return b, nil
+}
+
+type DatagramServer struct {
+	Addr string
+	// larger messages are dropped, keep it under the path MTU
+	MaxMsgLen     uint32
+	pc            net.PacketConn
+	sessions      map[uint64]*DatagramSession
+	mutexSessions sync.Mutex
+	wg            sync.WaitGroup
+}
+
+func (server *DatagramServer) Start() {
+	server.init()
+	server.wg.Add(1)
+	go server.run()
+}
+
+func (server *DatagramServer) init() {
+	pc, err := net.ListenPacket("udp", server.Addr)
+	if err != nil {
+		log.Fatal("%v", err)
+	}
+
+	if server.MaxMsgLen <= 0 {
+		server.MaxMsgLen = 1200
+		log.Release("invalid MaxMsgLen, reset to %v", server.MaxMsgLen)
+	}
+
+	server.pc = pc
+	server.sessions = make(map[uint64]*DatagramSession)
+}
+
+// LocalAddr returns the address the server listens on
+func (server *DatagramServer) LocalAddr() net.Addr {
+	return server.pc.LocalAddr()
+}
+
+// NewSession returns a session of a new token, onRecv gets its messages
+// on the reader goroutine of the server
+//
+// goroutine safe
+func (server *DatagramServer) NewSession(onRecv func(msg []byte)) *DatagramSession {
+	s := &DatagramSession{server: server, onRecv: onRecv}
+
+	server.mutexSessions.Lock()
+	defer server.mutexSessions.Unlock()
+	for {
+		var b [8]byte
+		if _, err := rand.Read(b[:]); err != nil {
+			log.Fatal("%v", err)
+		}
+		s.token = binary.LittleEndian.Uint64(b[:])
+		if _, ok := server.sessions[s.token]; !ok && s.token != 0 {
+			break
+		}
+	}
+	server.sessions[s.token] = s
+	return s
+}
+
+func (server *DatagramServer) run() {
+	defer server.wg.Done()
+
+	buf := make([]byte, 64*1024)
+	for {
+		n, addr, err := server.pc.ReadFrom(buf)
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if err != nil {
+			log.DebugF("datagram read error: %v", err)
+			continue
+		}
+		if n < datagramHeaderLen {
+			continue
+		}
+		datagramReadBytes.Add(uint64(n))
+
+		token := binary.LittleEndian.Uint64(buf)
+		server.mutexSessions.Lock()
+		s := server.sessions[token]
+		server.mutexSessions.Unlock()
+		if s == nil {
+			datagramDropped("unknown").Inc()
+			continue
+		}
+		if uint32(n-datagramHeaderLen) > server.MaxMsgLen {
+			datagramDropped("too_long").Inc()
+			continue
+		}
+		s.input(binary.LittleEndian.Uint32(buf[8:]), buf[datagramHeaderLen:n], addr)
+	}
+}
+
+func (server *DatagramServer) Close() {
+	server.pc.Close()
+	server.wg.Wait()
+
+	server.mutexSessions.Lock()
+	server.sessions = make(map[uint64]*DatagramSession)
+	server.mutexSessions.Unlock()
+}
+
+// DatagramSession is the datagram channel of one peer
+type DatagramSession struct {
+	sync.Mutex
+	server  *DatagramServer
+	token   uint64
+	addr    net.Addr
+	sendSeq uint32
+	recvSeq uint32
+	onRecv  func(msg []byte)
+}
+
+func (s *DatagramSession) input(seq uint32, msg []byte, addr net.Addr) {
+	s.Lock()
+	if s.addr != nil && !seqNewer(seq, s.recvSeq) {
+		s.Unlock()
+		datagramDropped("stale").Inc()
+		return
+	}
+	// follows the peer across a NAT rebinding
+	s.addr = addr
+	s.recvSeq = seq
+	s.Unlock()
+
+	if len(msg) > 0 && s.onRecv != nil {
+		s.onRecv(append([]byte(nil), msg...))
+	}
+}
+
+// Token is handed to the peer through the reliable session
+func (s *DatagramSession) Token() uint64 {
+	return s.token
+}
+
+// RemoteAddr is nil until the peer sends a datagram
+func (s *DatagramSession) RemoteAddr() net.Addr {
+	s.Lock()
+	defer s.Unlock()
+	return s.addr
+}
+
+// goroutine safe
+func (s *DatagramSession) WriteMsg(args ...[]byte) error {
+	s.Lock()
+	addr := s.addr
+	if addr == nil {
+		s.Unlock()
+		datagramDropped("unbound").Inc()
+		return errDatagramUnbound
+	}
+	s.sendSeq++
+	b, err := datagramPacket(s.token, s.sendSeq, s.server.MaxMsgLen, args)
+	s.Unlock()
+	if err != nil {
+		return err
+	}
+
+	datagramWriteBytes.Add(uint64(len(b)))
+	_, err = s.server.pc.WriteTo(b, addr)
+	return err
+}
+
+// Close forgets the token, the later datagrams of the peer are dropped
+func (s *DatagramSession) Close() {
+	s.server.mutexSessions.Lock()
+	if s.server.sessions[s.token] == s {
+		delete(s.server.sessions, s.token)
+	}
+	s.server.mutexSessions.Unlock()
+}
+
+// DatagramClient is the peer side of a DatagramSession
+type DatagramClient struct {
+	sync.Mutex
+	conn      *net.UDPConn
+	token     uint64
+	maxMsgLen uint32
+	sendSeq   uint32
+	recvSeq   uint32
+	recvAny   bool
+}
+
+// DialDatagram opens the channel of token and tells the server the address
+// of the client. The hello may be lost, the later messages bind it as well
+func DialDatagram(addr string, token uint64, maxMsgLen uint32) (*DatagramClient, error) {
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.DialUDP("udp", nil, udpAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	client := &DatagramClient{conn: conn, token: token, maxMsgLen: maxMsgLen}
+	if err := client.Hello(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return client, nil
+}
+
+// Hello sends a datagram without message
+func (client *DatagramClient) Hello() error {
+	client.Lock()
+	client.sendSeq++
+	b := make([]byte, datagramHeaderLen)
+	putDatagramHeader(b, client.token, client.sendSeq)
+	client.Unlock()
+
+	_, err := client.conn.Write(b)
+	return err
+}
+
+// goroutine safe
+func (client *DatagramClient) WriteMsg(args ...[]byte) error {
+	client.Lock()
+	client.sendSeq++
+	b, err := datagramPacket(client.token, client.sendSeq, client.maxMsgLen, args)
+	client.Unlock()
+	if err != nil {
+		return err
+	}
+
+	_, err = client.conn.Write(b)
+	return err
+}
+
+// ReadMsg skips the stale datagrams
+//
+// goroutine not safe
+func (client *DatagramClient) ReadMsg() ([]byte, error) {
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := client.conn.Read(buf)
+		if errors.Is(err, net.ErrClosed) {
+			return nil, err
+		}
+		if err != nil {
+			// no server yet
+			time.Sleep(10 * time.Millisecond)
+			continue
+		}
+		if n <= datagramHeaderLen || binary.LittleEndian.Uint64(buf) != client.token {
+			continue
+		}
+
+		seq := binary.LittleEndian.Uint32(buf[8:])
+		client.Lock()
+		stale := client.recvAny && !seqNewer(seq, client.recvSeq)
+		if !stale {
+			client.recvAny = true
+			client.recvSeq = seq
+		}
+		client.Unlock()
+		if stale {
+			continue
+		}
+		return append([]byte(nil), buf[datagramHeaderLen:n]...), nil
+	}
+}
+
+func (client *DatagramClient) LocalAddr() net.Addr {
+	return client.conn.LocalAddr()
+}
+
+func (client *DatagramClient) Close() {
+	client.conn.Close()
+}
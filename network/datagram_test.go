@@ -0,0 +1,114 @@
+package network_test
+
+import (
+	"encoding/binary"
+	"gitee.com/aarlin/leaflet/network"
+	"net"
+	"testing"
+	"time"
+)
+
+func datagram(token uint64, seq uint32, msg string) []byte {
+	b := make([]byte, 12+len(msg))
+	binary.LittleEndian.PutUint64(b, token)
+	binary.LittleEndian.PutUint32(b[8:], seq)
+	copy(b[12:], msg)
+	return b
+}
+
+func TestDatagramStale(t *testing.T) {
+	server := &network.DatagramServer{Addr: "127.0.0.1:0", MaxMsgLen: 1200}
+	server.Start()
+	defer server.Close()
+
+	recv := make(chan string, 10)
+	s := server.NewSession(func(msg []byte) {
+		recv <- string(msg)
+	})
+	if err := s.WriteMsg([]byte("early")); err == nil {
+		t.Fatal("write before the peer's first datagram")
+	}
+
+	c, err := net.Dial("udp", server.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	// the stale and the foreign ones are dropped
+	c.Write(datagram(s.Token(), 5, "a"))
+	c.Write(datagram(s.Token(), 3, "stale"))
+	c.Write(datagram(s.Token()+1, 6, "foreign"))
+	c.Write(datagram(s.Token(), 6, "b"))
+	for _, want := range []string{"a", "b"} {
+		select {
+		case got := <-recv:
+			if got != want {
+				t.Fatalf("got %q, want %q", got, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("timeout")
+		}
+	}
+
+	// the session knows the address of the peer now
+	if err := s.WriteMsg([]byte("he"), []byte("llo")); err != nil {
+		t.Fatal(err)
+	}
+	c.SetReadDeadline(time.Now().Add(5 * time.Second))
+	b := make([]byte, 64)
+	n, err := c.Read(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b[12:n]) != "hello" || binary.LittleEndian.Uint64(b) != s.Token() {
+		t.Fatalf("bad datagram %q", b[:n])
+	}
+
+	// the closed session ignores its peer
+	s.Close()
+	c.Write(datagram(s.Token(), 7, "late"))
+	select {
+	case got := <-recv:
+		t.Fatalf("got %q after close", got)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestDatagramClient(t *testing.T) {
+	server := &network.DatagramServer{Addr: "127.0.0.1:0", MaxMsgLen: 1200}
+	server.Start()
+	defer server.Close()
+
+	recv := make(chan string, 10)
+	s := server.NewSession(func(msg []byte) {
+		recv <- string(msg)
+	})
+
+	client, err := network.DialDatagram(server.LocalAddr().String(), s.Token(), 1200)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	if err := client.WriteMsg([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case got := <-recv:
+		if got != "ping" {
+			t.Fatalf("server got %q", got)
+		}
+		s.WriteMsg([]byte(got))
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout")
+	}
+
+	echo, err := client.ReadMsg()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(echo) != "ping" {
+		t.Fatalf("client got %q", echo)
+	}
+}
@@ -18,6 +18,9 @@ type MsgInfo struct {
 	msgRouter     *chanrpc.Server
 	msgHandler    MsgHandler
 	msgRawHandler MsgHandler
+	// the datagram channel
+	datagramRouter  *chanrpc.Server
+	datagramHandler MsgHandler
 }
 
 type MsgHandler func([]interface{})
@@ -126,6 +129,58 @@ func (p *Processor) Route(msg interface{}, userData interface{}) error {
 	return nil
 }
 
+// It's dangerous to call the method on routing or marshaling (unmarshaling)
+func (p *Processor) SetDatagramRouter(msg interface{}, msgRouter *chanrpc.Server) {
+	msgType := reflect.TypeOf(msg)
+	if msgType == nil || msgType.Kind() != reflect.Ptr {
+		log.Fatal("json message pointer required")
+	}
+	msgID := msgType.Elem().Name()
+	i, ok := p.msgInfo[msgID]
+	if !ok {
+		log.Fatal("message %v not registered", msgID)
+	}
+
+	i.datagramRouter = msgRouter
+}
+
+// It's dangerous to call the method on routing or marshaling (unmarshaling)
+func (p *Processor) SetDatagramHandler(msg interface{}, msgHandler MsgHandler) {
+	msgType := reflect.TypeOf(msg)
+	if msgType == nil || msgType.Kind() != reflect.Ptr {
+		log.Fatal("json message pointer required")
+	}
+	msgID := msgType.Elem().Name()
+	i, ok := p.msgInfo[msgID]
+	if !ok {
+		log.Fatal("message %v not registered", msgID)
+	}
+
+	i.datagramHandler = msgHandler
+}
+
+// RouteDatagram routes a message of the datagram channel, the messages
+// without datagram router or handler take the route of Route
+//
+// goroutine safe
+func (p *Processor) RouteDatagram(msg interface{}, userData interface{}) error {
+	msgType := reflect.TypeOf(msg)
+	if msgType == nil || msgType.Kind() != reflect.Ptr {
+		return p.Route(msg, userData)
+	}
+	i, ok := p.msgInfo[msgType.Elem().Name()]
+	if !ok || i.datagramRouter == nil && i.datagramHandler == nil {
+		return p.Route(msg, userData)
+	}
+	if i.datagramHandler != nil {
+		i.datagramHandler([]interface{}{msg, userData})
+	}
+	if i.datagramRouter != nil {
+		i.datagramRouter.Go(msgType, msg, userData)
+	}
+	return nil
+}
+
 // goroutine safe
 func (p *Processor) Unmarshal(data []byte) (interface{}, error) {
 	var m map[string]json.RawMessage
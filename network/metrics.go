@@ -0,0 +1,52 @@
+package network
+
+import "gitee.com/aarlin/leaflet/metrics"
+
+var (
+	tcpReadBytes = metrics.NewCounter("network_read_bytes_total",
+		"bytes read from the connections", "kind", "tcp")
+	tcpWriteBytes = metrics.NewCounter("network_write_bytes_total",
+		"bytes written to the connections", "kind", "tcp")
+	tcpWriteFull = metrics.NewCounter("network_write_channel_full_total",
+		"connections closed because the write channel was full", "kind", "tcp")
+	tcpWriteDropped = metrics.NewCounter("network_write_dropped_total",
+		"messages dropped because the write channel was full", "kind", "tcp")
+
+	wsReadBytes = metrics.NewCounter("network_read_bytes_total",
+		"bytes read from the connections", "kind", "ws")
+	wsWriteBytes = metrics.NewCounter("network_write_bytes_total",
+		"bytes written to the connections", "kind", "ws")
+	wsWriteFull = metrics.NewCounter("network_write_channel_full_total",
+		"connections closed because the write channel was full", "kind", "ws")
+	wsWriteDropped = metrics.NewCounter("network_write_dropped_total",
+		"messages dropped because the write channel was full", "kind", "ws")
+
+	udpReadBytes = metrics.NewCounter("network_read_bytes_total",
+		"bytes read from the connections", "kind", "udp")
+	udpWriteBytes = metrics.NewCounter("network_write_bytes_total",
+		"bytes written to the connections", "kind", "udp")
+	udpWriteFull = metrics.NewCounter("network_write_channel_full_total",
+		"connections closed because the write channel was full", "kind", "udp")
+	udpRetrans = metrics.NewCounter("network_udp_retransmits_total",
+		"segments sent again by the reliable udp connections")
+
+	datagramReadBytes = metrics.NewCounter("network_read_bytes_total",
+		"bytes read from the connections", "kind", "datagram")
+	datagramWriteBytes = metrics.NewCounter("network_write_bytes_total",
+		"bytes written to the connections", "kind", "datagram")
+)
+
+func datagramDropped(reason string) *metrics.Counter {
+	return metrics.NewCounter("network_datagrams_dropped_total",
+		"datagrams of the unreliable channel dropped", "reason", reason)
+}
+
+func connsGauge(kind string, addr string) *metrics.Gauge {
+	return metrics.NewGauge("network_conns", "connections held by a server",
+		"kind", kind, "server", addr)
+}
+
+func rejectedCounter(kind string, addr string) *metrics.Counter {
+	return metrics.NewCounter("network_conns_rejected_total",
+		"connections refused because MaxConnNum was reached", "kind", kind, "server", addr)
+}
@@ -8,3 +8,10 @@ type Processor interface {
 	// must goroutine safe
 	Marshal(msg interface{}) ([][]byte, error)
 }
+
+// DatagramProcessor is implemented by the processors which route the
+// messages of the datagram channel apart from the reliable ones
+type DatagramProcessor interface {
+	// must goroutine safe
+	RouteDatagram(msg interface{}, userData interface{}) error
+}
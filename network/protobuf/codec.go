@@ -0,0 +1,92 @@
+package protobuf
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/golang/protobuf/proto"
+	"reflect"
+)
+
+// Codec encodes chanrpc arguments, every argument must be a protobuf message
+//
+// ------------------------------------------------
+// | len | message name | len | protobuf message | ...
+// ------------------------------------------------
+type Codec struct{}
+
+func NewCodec() *Codec {
+	return new(Codec)
+}
+
+// goroutine safe
+func (c *Codec) Marshal(args []interface{}) ([]byte, error) {
+	var b []byte
+	for _, arg := range args {
+		if arg == nil {
+			b = binary.AppendUvarint(b, 0)
+			b = binary.AppendUvarint(b, 0)
+			continue
+		}
+
+		msg, ok := arg.(proto.Message)
+		if !ok {
+			return nil, fmt.Errorf("protobuf message required, got %v", reflect.TypeOf(arg))
+		}
+		data, err := proto.Marshal(msg)
+		if err != nil {
+			return nil, err
+		}
+
+		name := proto.MessageName(msg)
+		b = binary.AppendUvarint(b, uint64(len(name)))
+		b = append(b, name...)
+		b = binary.AppendUvarint(b, uint64(len(data)))
+		b = append(b, data...)
+	}
+
+	return b, nil
+}
+
+func readBytes(b []byte) ([]byte, []byte, error) {
+	l, n := binary.Uvarint(b)
+	if n <= 0 || uint64(len(b)-n) < l {
+		return nil, nil, errors.New("protobuf data too short")
+	}
+	return b[n : n+int(l)], b[n+int(l):], nil
+}
+
+// goroutine safe
+func (c *Codec) Unmarshal(b []byte) ([]interface{}, error) {
+	var args []interface{}
+	for len(b) > 0 {
+		var name, data []byte
+		var err error
+		name, b, err = readBytes(b)
+		if err != nil {
+			return nil, err
+		}
+		data, b, err = readBytes(b)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(name) == 0 {
+			args = append(args, nil)
+			continue
+		}
+
+		msgType := proto.MessageType(string(name))
+		if msgType == nil {
+			return nil, fmt.Errorf("message %s not registered", name)
+		}
+		msg := reflect.New(msgType.Elem()).Interface().(proto.Message)
+		err = proto.Unmarshal(data, msg)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, msg)
+	}
+
+	return args, nil
+}
@@ -25,6 +25,9 @@ type MsgInfo struct {
 	msgRouter     *chanrpc.Server
 	msgHandler    MsgHandler
 	msgRawHandler MsgHandler
+	// the datagram channel
+	datagramRouter  *chanrpc.Server
+	datagramHandler MsgHandler
 }
 
 type MsgHandler func([]interface{})
@@ -136,6 +139,53 @@ func (p *Processor) Route(msg interface{}, userData interface{}) error {
 	return nil
 }
 
+// It's dangerous to call the method on routing or marshaling (unmarshaling)
+func (p *Processor) SetDatagramRouter(msg proto.Message, msgRouter *chanrpc.Server) {
+	msgType := reflect.TypeOf(msg)
+	id, ok := p.msgID[msgType]
+	if !ok {
+		log.Fatal("message %s not registered", msgType)
+		return
+	}
+
+	p.msgInfo[id].datagramRouter = msgRouter
+}
+
+// It's dangerous to call the method on routing or marshaling (unmarshaling)
+func (p *Processor) SetDatagramHandler(msg proto.Message, msgHandler MsgHandler) {
+	msgType := reflect.TypeOf(msg)
+	id, ok := p.msgID[msgType]
+	if !ok {
+		log.Fatal("message %s not registered", msgType)
+		return
+	}
+
+	p.msgInfo[id].datagramHandler = msgHandler
+}
+
+// RouteDatagram routes a message of the datagram channel, the messages
+// without datagram router or handler take the route of Route
+//
+// goroutine safe
+func (p *Processor) RouteDatagram(msg interface{}, userData interface{}) error {
+	msgType := reflect.TypeOf(msg)
+	id, ok := p.msgID[msgType]
+	if !ok {
+		return p.Route(msg, userData)
+	}
+	i := p.msgInfo[id]
+	if i.datagramRouter == nil && i.datagramHandler == nil {
+		return p.Route(msg, userData)
+	}
+	if i.datagramHandler != nil {
+		i.datagramHandler([]interface{}{msg, userData})
+	}
+	if i.datagramRouter != nil {
+		i.datagramRouter.Go(msgType, msg, userData)
+	}
+	return nil
+}
+
 // goroutine safe
 func (p *Processor) Unmarshal(data []byte) (interface{}, error) {
 	if len(data) < 2 {
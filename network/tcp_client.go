@@ -25,6 +25,24 @@ type TCPClient struct {
 	//MaxMsgLen    uint32
 	//LittleEndian bool
 	TcpParser TcpParser
+
+	// the server silent for longer is disconnected
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+	// pings the server silent for PingInterval, answers its pings with
+	// PongMsg once any of the three is set, the heartbeat messages do not
+	// reach the agents then
+	PingInterval time.Duration
+	PingMsg      []byte
+	PongMsg      []byte
+	liveness     tcpLiveness
+
+	// when PendingWriteNum messages are waiting
+	OverflowPolicy    OverflowPolicy
+	WriteBlockTimeout time.Duration
+	// the priority class of a message for OverflowDropPriority
+	WritePriority func(args [][]byte) int
+	writePolicy   writePolicy
 }
 
 func (client *TCPClient) Start() {
@@ -61,6 +79,9 @@ func (client *TCPClient) init() {
 
 	client.conns = make(ConnSet)
 	client.closeFlag = false
+	client.liveness = newTCPLiveness(client.ReadTimeout, client.WriteTimeout, client.PingInterval,
+		client.PingMsg, client.PongMsg, client.TcpParser)
+	client.writePolicy = newWritePolicy(client.OverflowPolicy, client.WriteBlockTimeout, client.WritePriority)
 
 	// msg parser
 	//TcpParser := NewMsgParser()
@@ -100,7 +121,7 @@ reconnect:
 	client.conns[conn] = struct{}{}
 	client.Unlock()
 
-	tcpConn := newTCPConn(conn, client.PendingWriteNum, client.TcpParser)
+	tcpConn := newTCPConn(conn, client.PendingWriteNum, client.TcpParser, client.liveness, client.writePolicy)
 	agent := client.NewAgent(tcpConn)
 	agent.Run()
 
@@ -111,7 +132,11 @@ reconnect:
 	client.Unlock()
 	agent.OnClose()
 
-	if client.AutoReconnect {
+	client.Lock()
+	closeFlag := client.closeFlag
+	client.Unlock()
+
+	if client.AutoReconnect && !closeFlag {
 		time.Sleep(client.ConnectInterval)
 		goto reconnect
 	}
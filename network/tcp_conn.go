@@ -1,40 +1,116 @@
 package network
 
 import (
+	"bytes"
+	"errors"
 	"gitee.com/aarlin/leaflet/log"
 	"net"
 	"sync"
+	"time"
 )
 
 type ConnSet map[net.Conn]struct{}
 
+// tcpLiveness is the part of the config of TCPServer and TCPClient
+// about the dead peers
+type tcpLiveness struct {
+	readTimeout  time.Duration
+	writeTimeout time.Duration
+	pingInterval time.Duration
+	pingMsg      []byte
+	pongMsg      []byte
+	// PingInterval, PingMsg or PongMsg is set, the heartbeat messages
+	// are taken out of the reads
+	heartbeat bool
+}
+
+// the default heartbeat messages, too short for the processors. A parser
+// with a MinMsgLen above 1 needs its own
+var (
+	defaultPingMsg = []byte{0}
+	defaultPongMsg = []byte{1}
+)
+
+// discardConn takes the writes of a parser checking the heartbeat messages
+type discardConn struct {
+	Conn
+}
+
+func (discardConn) Write(b []byte) {}
+
+// the pings of the peer are answered whatever PingInterval once the
+// heartbeat is on, the heartbeat messages must pass the parser. Without
+// it every message reaches the agent
+func newTCPLiveness(readTimeout, writeTimeout, pingInterval time.Duration, pingMsg, pongMsg []byte, msgParser TcpParser) tcpLiveness {
+	l := tcpLiveness{readTimeout: readTimeout, writeTimeout: writeTimeout, pingInterval: pingInterval,
+		pingMsg: pingMsg, pongMsg: pongMsg}
+	l.heartbeat = pingInterval > 0 || len(pingMsg) > 0 || len(pongMsg) > 0
+	if !l.heartbeat {
+		return l
+	}
+
+	if len(l.pingMsg) == 0 {
+		l.pingMsg = defaultPingMsg
+	}
+	if len(l.pongMsg) == 0 {
+		l.pongMsg = defaultPongMsg
+	}
+	if err := msgParser.Write(discardConn{}, l.pingMsg); err != nil {
+		log.FatalF("invalid PingMsg: %v", err)
+	}
+	if err := msgParser.Write(discardConn{}, l.pongMsg); err != nil {
+		log.FatalF("invalid PongMsg: %v", err)
+	}
+	return l
+}
+
+var errReadIdle = errors.New("read idle timeout")
+
 type TCPConn struct {
 	sync.Mutex
-	conn      net.Conn
-	writeChan chan []byte
-	closeFlag bool
-	msgParser TcpParser
+	conn       net.Conn
+	writeQueue *writeQueue
+	closeFlag  bool
+	msgParser  TcpParser
+	liveness   tcpLiveness
+	// the reader goroutine only
+	lastRead time.Time
+	lastPing time.Time
 }
 
-func newTCPConn(conn net.Conn, pendingWriteNum int, msgParser TcpParser) *TCPConn {
+func newTCPConn(conn net.Conn, pendingWriteNum int, msgParser TcpParser, liveness tcpLiveness, policy writePolicy) *TCPConn {
 	tcpConn := new(TCPConn)
 	tcpConn.conn = conn
-	tcpConn.writeChan = make(chan []byte, pendingWriteNum)
+	tcpConn.writeQueue = newWriteQueue(pendingWriteNum, policy)
 	tcpConn.msgParser = msgParser
+	tcpConn.liveness = liveness
+	tcpConn.lastRead = time.Now()
+	tcpConn.lastPing = tcpConn.lastRead
 
 	go func() {
-		for b := range tcpConn.writeChan {
-			if b == nil {
+		// the waiting messages go in one writev
+		var batch [][]byte
+		for {
+			var ok bool
+			batch, ok = tcpConn.writeQueue.pop(batch)
+			if !ok {
 				break
 			}
 
-			_, err := conn.Write(b)
+			if liveness.writeTimeout > 0 {
+				conn.SetWriteDeadline(time.Now().Add(liveness.writeTimeout))
+			}
+			bufs := net.Buffers(batch)
+			n, err := bufs.WriteTo(conn)
+			tcpWriteBytes.Add(uint64(n))
 			if err != nil {
+				log.DebugF("close conn %v: write error: %v", conn.RemoteAddr(), err)
 				break
 			}
 		}
 
 		conn.Close()
+		tcpConn.writeQueue.destroy()
 		tcpConn.Lock()
 		tcpConn.closeFlag = true
 		tcpConn.Unlock()
@@ -46,11 +122,8 @@ func newTCPConn(conn net.Conn, pendingWriteNum int, msgParser TcpParser) *TCPCon
 func (tcpConn *TCPConn) doDestroy() {
 	tcpConn.conn.(*net.TCPConn).SetLinger(0)
 	tcpConn.conn.Close()
-
-	if !tcpConn.closeFlag {
-		close(tcpConn.writeChan)
-		tcpConn.closeFlag = true
-	}
+	tcpConn.writeQueue.destroy()
+	tcpConn.closeFlag = true
 }
 
 func (tcpConn *TCPConn) Destroy() {
@@ -67,33 +140,100 @@ func (tcpConn *TCPConn) Close() {
 		return
 	}
 
-	tcpConn.doWrite(nil)
+	tcpConn.writeQueue.close()
 	tcpConn.closeFlag = true
 }
 
-func (tcpConn *TCPConn) doWrite(b []byte) {
-	if len(tcpConn.writeChan) == cap(tcpConn.writeChan) {
-		log.Debug("close conn: channel full")
-		tcpConn.doDestroy()
-		return
+// write queues b by the overflow policy, it may wait with OverflowBlock
+func (tcpConn *TCPConn) write(b []byte, prio int) error {
+	tcpConn.Lock()
+	closeFlag := tcpConn.closeFlag
+	tcpConn.Unlock()
+	if closeFlag || b == nil {
+		return nil
 	}
 
-	tcpConn.writeChan <- b
+	err := tcpConn.writeQueue.push(b, prio)
+	if err == nil {
+		return nil
+	}
+	if err == ErrWriteFull {
+		log.DebugF("close conn %v: %v", tcpConn.RemoteAddr(), err)
+		tcpWriteFull.Inc()
+		tcpConn.Destroy()
+	} else {
+		tcpWriteDropped.Inc()
+	}
+	return err
 }
 
 // b must not be modified by the others goroutines
 func (tcpConn *TCPConn) Write(b []byte) {
-	tcpConn.Lock()
-	defer tcpConn.Unlock()
-	if tcpConn.closeFlag || b == nil {
-		return
-	}
+	tcpConn.write(b, 0)
+}
 
-	tcpConn.doWrite(b)
+// readWait is how long the peer may stay silent before the next check,
+// 0 without ReadTimeout and PingInterval
+func (tcpConn *TCPConn) readWait(now time.Time) time.Duration {
+	var wait time.Duration
+	if tcpConn.liveness.readTimeout > 0 {
+		wait = tcpConn.lastRead.Add(tcpConn.liveness.readTimeout).Sub(now)
+	}
+	if tcpConn.liveness.pingInterval > 0 {
+		last := tcpConn.lastRead
+		if tcpConn.lastPing.After(last) {
+			last = tcpConn.lastPing
+		}
+		ping := last.Add(tcpConn.liveness.pingInterval).Sub(now)
+		if tcpConn.liveness.readTimeout <= 0 || ping < wait {
+			wait = ping
+		}
+	}
+	if wait <= 0 && (tcpConn.liveness.readTimeout > 0 || tcpConn.liveness.pingInterval > 0) {
+		wait = time.Millisecond
+	}
+	return wait
 }
 
+// Read times out rather than the message parser, so that no message is
+// cut in the middle
+//
+// goroutine not safe
 func (tcpConn *TCPConn) Read(b []byte) (int, error) {
-	return tcpConn.conn.Read(b)
+	for {
+		wait := tcpConn.readWait(time.Now())
+		if wait > 0 {
+			tcpConn.conn.SetReadDeadline(time.Now().Add(wait))
+		}
+
+		n, err := tcpConn.conn.Read(b)
+		tcpReadBytes.Add(uint64(n))
+		ne, timeout := err.(net.Error)
+		timeout = timeout && ne.Timeout() && wait > 0
+		if n > 0 {
+			tcpConn.lastRead = time.Now()
+			if timeout {
+				err = nil
+			}
+			return n, err
+		}
+		if !timeout {
+			return n, err
+		}
+
+		now := time.Now()
+		if tcpConn.liveness.readTimeout > 0 && now.Sub(tcpConn.lastRead) >= tcpConn.liveness.readTimeout {
+			log.DebugF("close conn %v: %v", tcpConn.RemoteAddr(), errReadIdle)
+			return 0, errReadIdle
+		}
+		if tcpConn.liveness.pingInterval > 0 && now.Sub(tcpConn.lastRead) >= tcpConn.liveness.pingInterval &&
+			now.Sub(tcpConn.lastPing) >= tcpConn.liveness.pingInterval {
+			tcpConn.lastPing = now
+			if err := tcpConn.WriteMsg(tcpConn.liveness.pingMsg); err != nil {
+				log.DebugF("ping %v: %v", tcpConn.RemoteAddr(), err)
+			}
+		}
+	}
 }
 
 func (tcpConn *TCPConn) LocalAddr() net.Addr {
@@ -104,10 +244,47 @@ func (tcpConn *TCPConn) RemoteAddr() net.Addr {
 	return tcpConn.conn.RemoteAddr()
 }
 
+// the heartbeat messages do not reach the agent once the heartbeat is on,
+// with or without PingInterval
 func (tcpConn *TCPConn) ReadMsg() ([]byte, error) {
-	return tcpConn.msgParser.Read(tcpConn)
+	for {
+		msg, err := tcpConn.msgParser.Read(tcpConn)
+		if err != nil || !tcpConn.liveness.heartbeat {
+			return msg, err
+		}
+		if bytes.Equal(msg, tcpConn.liveness.pingMsg) {
+			if err := tcpConn.WriteMsg(tcpConn.liveness.pongMsg); err != nil {
+				log.DebugF("pong %v: %v", tcpConn.RemoteAddr(), err)
+			}
+			continue
+		}
+		if bytes.Equal(msg, tcpConn.liveness.pongMsg) {
+			continue
+		}
+		return msg, nil
+	}
+}
+
+// tcpWriteCall passes the priority of a message to the Write of the
+// parser and gets the result back
+type tcpWriteCall struct {
+	*TCPConn
+	prio int
+	err  error
 }
 
+func (c *tcpWriteCall) Write(b []byte) {
+	c.err = c.TCPConn.write(b, c.prio)
+}
+
+// WriteMsg returns ErrWriteFull, ErrWriteTimeout or ErrMsgDropped when the
+// messages waiting fill PendingWriteNum, by the overflow policy
+//
+// args must not be modified by the others goroutines
 func (tcpConn *TCPConn) WriteMsg(args ...[]byte) error {
-	return tcpConn.msgParser.Write(tcpConn, args...)
+	c := &tcpWriteCall{TCPConn: tcpConn, prio: tcpConn.writeQueue.policy.priorityOf(args)}
+	if err := tcpConn.msgParser.Write(c, args...); err != nil {
+		return err
+	}
+	return c.err
 }
@@ -2,6 +2,7 @@ package network
 
 import (
 	"gitee.com/aarlin/leaflet/log"
+	"gitee.com/aarlin/leaflet/metrics"
 	"net"
 	"sync"
 	"time"
@@ -17,6 +18,8 @@ type TCPServer struct {
 	mutexConns      sync.Mutex
 	wgLn            sync.WaitGroup
 	wgConns         sync.WaitGroup
+	connsGauge      *metrics.Gauge
+	rejected        *metrics.Counter
 
 	// msg parser
 	//LenMsgLen    int
@@ -24,6 +27,24 @@ type TCPServer struct {
 	//MaxMsgLen    uint32
 	//LittleEndian bool
 	TcpParser    TcpParser
+
+	// peers silent for longer are disconnected
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+	// pings the peers silent for PingInterval, answers their pings with
+	// PongMsg once any of the three is set, the heartbeat messages do not
+	// reach the agents then
+	PingInterval time.Duration
+	PingMsg      []byte
+	PongMsg      []byte
+	liveness     tcpLiveness
+
+	// when PendingWriteNum messages are waiting
+	OverflowPolicy    OverflowPolicy
+	WriteBlockTimeout time.Duration
+	// the priority class of a message for OverflowDropPriority
+	WritePriority func(args [][]byte) int
+	writePolicy   writePolicy
 }
 
 func (server *TCPServer) Start() {
@@ -51,6 +72,11 @@ func (server *TCPServer) init() {
 
 	server.ln = ln
 	server.conns = make(ConnSet)
+	server.liveness = newTCPLiveness(server.ReadTimeout, server.WriteTimeout, server.PingInterval,
+		server.PingMsg, server.PongMsg, server.TcpParser)
+	server.writePolicy = newWritePolicy(server.OverflowPolicy, server.WriteBlockTimeout, server.WritePriority)
+	server.connsGauge = connsGauge("tcp", server.Addr)
+	server.rejected = rejectedCounter("tcp", server.Addr)
 
 	// msg parser
 	//msgParser := NewMsgParser()
@@ -59,6 +85,11 @@ func (server *TCPServer) init() {
 	//server.msgParser = msgParser
 }
 
+// LocalAddr returns the address the server listens on
+func (server *TCPServer) LocalAddr() net.Addr {
+	return server.ln.Addr()
+}
+
 func (server *TCPServer) run() {
 	server.wgLn.Add(1)
 	defer server.wgLn.Done()
@@ -88,15 +119,17 @@ func (server *TCPServer) run() {
 		if len(server.conns) >= server.MaxConnNum {
 			server.mutexConns.Unlock()
 			conn.Close()
+			server.rejected.Inc()
 			log.Debug("too many connections")
 			continue
 		}
 		server.conns[conn] = struct{}{}
 		server.mutexConns.Unlock()
+		server.connsGauge.Inc()
 
 		server.wgConns.Add(1)
 
-		tcpConn := newTCPConn(conn, server.PendingWriteNum, server.TcpParser)
+		tcpConn := newTCPConn(conn, server.PendingWriteNum, server.TcpParser, server.liveness, server.writePolicy)
 		agent := server.NewAgent(tcpConn)
 		go func() {
 			agent.Run()
@@ -106,6 +139,7 @@ func (server *TCPServer) run() {
 			server.mutexConns.Lock()
 			delete(server.conns, conn)
 			server.mutexConns.Unlock()
+			server.connsGauge.Dec()
 			agent.OnClose()
 
 			server.wgConns.Done()
@@ -113,6 +147,12 @@ func (server *TCPServer) run() {
 	}
 }
 
+// StopAccept closes the listener and keeps the connections
+func (server *TCPServer) StopAccept() {
+	server.ln.Close()
+	server.wgLn.Wait()
+}
+
 func (server *TCPServer) Close() {
 	server.ln.Close()
 	server.wgLn.Wait()
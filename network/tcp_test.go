@@ -0,0 +1,173 @@
+package network_test
+
+import (
+	"fmt"
+	"gitee.com/aarlin/leaflet/network"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+type tcpEchoAgent struct {
+	conn   *network.TCPConn
+	msgs   chan string
+	closed chan bool
+}
+
+func (a *tcpEchoAgent) Run() {
+	for {
+		msg, err := a.conn.ReadMsg()
+		if err != nil {
+			return
+		}
+		a.msgs <- string(msg)
+		a.conn.WriteMsg(msg)
+	}
+}
+
+func (a *tcpEchoAgent) OnClose() {
+	a.closed <- true
+}
+
+func TestTCPReadTimeout(t *testing.T) {
+	closed := make(chan bool, 1)
+	server := &network.TCPServer{
+		Addr:        "127.0.0.1:0",
+		TcpParser:   network.NewMsgParser(),
+		ReadTimeout: 200 * time.Millisecond,
+		NewAgent: func(conn *network.TCPConn) network.Agent {
+			return &tcpEchoAgent{conn: conn, msgs: make(chan string, 10), closed: closed}
+		},
+	}
+	server.Start()
+	defer server.Close()
+
+	// a half-open peer says nothing
+	c, err := net.Dial("tcp", server.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("idle conn not closed")
+	}
+}
+
+type tcpSilentAgent struct {
+	conn *network.TCPConn
+	wait time.Duration
+	done chan error
+}
+
+// the agent reads all along, the heartbeat is driven by the reads
+func (a *tcpSilentAgent) Run() {
+	go func() {
+		time.Sleep(a.wait)
+		a.conn.WriteMsg([]byte("hello"))
+	}()
+	msg, err := a.conn.ReadMsg()
+	if err == nil && string(msg) != "hello" {
+		err = fmt.Errorf("client got %q", msg)
+	}
+	a.done <- err
+}
+
+func (a *tcpSilentAgent) OnClose() {}
+
+func TestTCPHeartbeat(t *testing.T) {
+	msgs := make(chan string, 10)
+	closed := make(chan bool, 1)
+	server := &network.TCPServer{
+		Addr:        "127.0.0.1:0",
+		TcpParser:   network.NewMsgParser(),
+		ReadTimeout: 300 * time.Millisecond,
+		PongMsg:     []byte{1},
+		NewAgent: func(conn *network.TCPConn) network.Agent {
+			return &tcpEchoAgent{conn: conn, msgs: msgs, closed: closed}
+		},
+	}
+	server.Start()
+	defer server.Close()
+
+	// silent for longer than ReadTimeout, the pings of the client keep the
+	// conn, PongMsg turns the heartbeat on and the server answers them
+	// without PingInterval
+	done := make(chan error, 1)
+	client := &network.TCPClient{
+		Addr:         server.LocalAddr().String(),
+		TcpParser:    network.NewMsgParser(),
+		ReadTimeout:  300 * time.Millisecond,
+		PingInterval: 100 * time.Millisecond,
+		NewAgent: func(conn *network.TCPConn) network.Agent {
+			return &tcpSilentAgent{conn: conn, wait: time.Second, done: done}
+		},
+	}
+	client.Start()
+	defer client.Close()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout")
+	}
+
+	// the pings and the pongs do not reach the agent
+	if msg := <-msgs; msg != "hello" {
+		t.Fatalf("server got %q", msg)
+	}
+	select {
+	case <-closed:
+		t.Fatal("conn closed")
+	default:
+	}
+}
+
+// without the heartbeat the messages looking like pings reach the agent
+func TestTCPNoHeartbeat(t *testing.T) {
+	msgs := make(chan string, 10)
+	server := &network.TCPServer{
+		Addr:      "127.0.0.1:0",
+		TcpParser: network.NewMsgParser(),
+		NewAgent: func(conn *network.TCPConn) network.Agent {
+			return &tcpEchoAgent{conn: conn, msgs: msgs, closed: make(chan bool, 1)}
+		},
+	}
+	server.Start()
+	defer server.Close()
+
+	c, err := net.Dial("tcp", server.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	// | len(2) | 0 |, a ping of the default heartbeat
+	if _, err := c.Write([]byte{0, 1, 0}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case msg := <-msgs:
+		if msg != "\x00" {
+			t.Fatalf("server got %q", msg)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("message not delivered")
+	}
+
+	// the echo, not a pong
+	b := make([]byte, 3)
+	c.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := io.ReadFull(c, b); err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "\x00\x01\x00" {
+		t.Fatalf("client got %q", b)
+	}
+}
@@ -0,0 +1,444 @@
+package network
+
+import (
+	"encoding/binary"
+	"errors"
+	"time"
+)
+
+// a KCP style ARQ: selective and cumulative acks, fast retransmit and
+// no congestion control. A datagram is the conv followed by segments:
+//
+//	-------------------------------------------------
+//	| cmd | frg | wnd | ts | sn | una | len | data |
+//	-------------------------------------------------
+//	   1     2     2     4    4    4     2
+const (
+	arqCmdPush uint8 = iota + 1
+	arqCmdAck
+	arqCmdPing
+	arqCmdSyn
+	arqCmdSynAck
+	arqCmdFin
+)
+
+const (
+	arqConvLen   = 4
+	arqHeaderLen = 19
+	arqMTU       = 1400
+	arqMSS       = arqMTU - arqConvLen - arqHeaderLen
+	arqSndWnd    = 128
+	arqRcvWnd    = 256
+	// the segments of a message must fit in the window of the receiver
+	arqMaxMsgLen  = (arqRcvWnd - 1) * arqMSS
+	arqMinRTO     = 30
+	arqMaxRTO     = 60000
+	arqFastResend = 2
+	arqDeadLink   = 20
+)
+
+var arqStart = time.Now()
+
+// milliseconds, compared by their difference
+func arqNow() uint32 {
+	return uint32(time.Since(arqStart) / time.Millisecond)
+}
+
+func arqDiff(a, b uint32) int32 {
+	return int32(a - b)
+}
+
+type arqSegment struct {
+	cmd      uint8
+	frg      uint16
+	ts       uint32
+	sn       uint32
+	data     []byte
+	resendts uint32
+	rto      uint32
+	fastack  uint32
+	xmit     uint32
+}
+
+type arqAck struct {
+	sn uint32
+	ts uint32
+}
+
+type arq struct {
+	conv     uint32
+	interval uint32
+
+	sndUna uint32
+	sndNxt uint32
+	rcvNxt uint32
+	rmtWnd uint16
+
+	srtt   int32
+	rttvar int32
+	rto    uint32
+
+	// not sent yet, in flight, received out of order, received in order
+	sndQueue []*arqSegment
+	sndBuf   []*arqSegment
+	rcvBuf   []*arqSegment
+	rcvQueue []*arqSegment
+	acks     []arqAck
+	// control segments to send on the next flush
+	ctrls []uint8
+
+	// a segment was sent DeadLink times
+	dead bool
+	// segments sent again
+	retrans uint64
+
+	buf    []byte
+	output func(b []byte)
+}
+
+func newARQ(conv uint32, interval time.Duration, output func(b []byte)) *arq {
+	a := new(arq)
+	a.conv = conv
+	a.interval = uint32(interval / time.Millisecond)
+	if a.interval < 1 {
+		a.interval = 1
+	}
+	a.rmtWnd = arqRcvWnd
+	a.rto = 200
+	a.buf = make([]byte, arqConvLen, arqMTU)
+	binary.LittleEndian.PutUint32(a.buf, conv)
+	a.output = output
+	return a
+}
+
+// send splits msg into segments
+func (a *arq) send(msg []byte) error {
+	count := (len(msg) + arqMSS - 1) / arqMSS
+	if count == 0 {
+		return errors.New("message too short")
+	}
+	if count >= arqRcvWnd {
+		return errors.New("message too long")
+	}
+
+	for i := 0; i < count; i++ {
+		size := len(msg)
+		if size > arqMSS {
+			size = arqMSS
+		}
+		seg := &arqSegment{cmd: arqCmdPush, frg: uint16(count - 1 - i)}
+		seg.data = append([]byte(nil), msg[:size]...)
+		a.sndQueue = append(a.sndQueue, seg)
+		msg = msg[size:]
+	}
+	return nil
+}
+
+// recv returns the next whole message if any
+func (a *arq) recv() ([]byte, bool) {
+	if len(a.rcvQueue) == 0 {
+		return nil, false
+	}
+	count := int(a.rcvQueue[0].frg) + 1
+	if len(a.rcvQueue) < count {
+		return nil, false
+	}
+
+	var msg []byte
+	if count == 1 {
+		msg = a.rcvQueue[0].data
+	} else {
+		size := 0
+		for _, seg := range a.rcvQueue[:count] {
+			size += len(seg.data)
+		}
+		msg = make([]byte, 0, size)
+		for _, seg := range a.rcvQueue[:count] {
+			msg = append(msg, seg.data...)
+		}
+	}
+	for i := 0; i < count; i++ {
+		a.rcvQueue[i] = nil
+	}
+	a.rcvQueue = a.rcvQueue[count:]
+	a.moveRcvBuf()
+	return msg, true
+}
+
+// the segments waiting to be sent or acked
+func (a *arq) waitSnd() int {
+	return len(a.sndQueue) + len(a.sndBuf)
+}
+
+func (a *arq) wnd() uint16 {
+	if len(a.rcvQueue) < arqRcvWnd {
+		return uint16(arqRcvWnd - len(a.rcvQueue))
+	}
+	return 0
+}
+
+func (a *arq) updateRTT(rtt int32) {
+	if a.srtt == 0 {
+		a.srtt = rtt
+		a.rttvar = rtt / 2
+	} else {
+		delta := rtt - a.srtt
+		if delta < 0 {
+			delta = -delta
+		}
+		a.rttvar = (3*a.rttvar + delta) / 4
+		a.srtt = (7*a.srtt + rtt) / 8
+		if a.srtt < 1 {
+			a.srtt = 1
+		}
+	}
+
+	rto := uint32(a.srtt)
+	if v := uint32(4 * a.rttvar); v > a.interval {
+		rto += v
+	} else {
+		rto += a.interval
+	}
+	if rto < arqMinRTO {
+		rto = arqMinRTO
+	}
+	if rto > arqMaxRTO {
+		rto = arqMaxRTO
+	}
+	a.rto = rto
+}
+
+func (a *arq) shrinkBuf() {
+	if len(a.sndBuf) > 0 {
+		a.sndUna = a.sndBuf[0].sn
+	} else {
+		a.sndUna = a.sndNxt
+	}
+}
+
+// removes the segments acked by una
+func (a *arq) parseUna(una uint32) {
+	n := 0
+	for n < len(a.sndBuf) && arqDiff(una, a.sndBuf[n].sn) > 0 {
+		a.sndBuf[n] = nil
+		n++
+	}
+	a.sndBuf = a.sndBuf[n:]
+}
+
+func (a *arq) parseAck(sn uint32) {
+	if arqDiff(sn, a.sndUna) < 0 || arqDiff(sn, a.sndNxt) >= 0 {
+		return
+	}
+	for i, seg := range a.sndBuf {
+		if seg.sn == sn {
+			a.sndBuf = append(a.sndBuf[:i], a.sndBuf[i+1:]...)
+			return
+		}
+		if arqDiff(sn, seg.sn) < 0 {
+			return
+		}
+	}
+}
+
+func (a *arq) parseFastack(maxack uint32) {
+	for _, seg := range a.sndBuf {
+		if arqDiff(maxack, seg.sn) <= 0 {
+			break
+		}
+		seg.fastack++
+	}
+}
+
+func (a *arq) parseData(seg *arqSegment) {
+	if arqDiff(seg.sn, a.rcvNxt+arqRcvWnd) >= 0 || arqDiff(seg.sn, a.rcvNxt) < 0 {
+		return
+	}
+
+	// insert in order, drop the duplicates
+	i := len(a.rcvBuf)
+	for i > 0 {
+		d := arqDiff(seg.sn, a.rcvBuf[i-1].sn)
+		if d == 0 {
+			return
+		}
+		if d > 0 {
+			break
+		}
+		i--
+	}
+	a.rcvBuf = append(a.rcvBuf, nil)
+	copy(a.rcvBuf[i+1:], a.rcvBuf[i:])
+	a.rcvBuf[i] = seg
+	a.moveRcvBuf()
+}
+
+func (a *arq) moveRcvBuf() {
+	n := 0
+	for n < len(a.rcvBuf) && a.rcvBuf[n].sn == a.rcvNxt && len(a.rcvQueue) < arqRcvWnd {
+		a.rcvQueue = append(a.rcvQueue, a.rcvBuf[n])
+		a.rcvBuf[n] = nil
+		a.rcvNxt++
+		n++
+	}
+	a.rcvBuf = a.rcvBuf[n:]
+}
+
+// input parses a datagram, the control commands are returned
+func (a *arq) input(data []byte, ctrl func(cmd uint8)) error {
+	if len(data) < arqConvLen || binary.LittleEndian.Uint32(data) != a.conv {
+		return errors.New("invalid conv")
+	}
+	data = data[arqConvLen:]
+
+	current := arqNow()
+	acked := false
+	var maxack uint32
+	for len(data) > 0 {
+		if len(data) < arqHeaderLen {
+			return errors.New("invalid segment")
+		}
+		cmd := data[0]
+		frg := binary.LittleEndian.Uint16(data[1:])
+		wnd := binary.LittleEndian.Uint16(data[3:])
+		ts := binary.LittleEndian.Uint32(data[5:])
+		sn := binary.LittleEndian.Uint32(data[9:])
+		una := binary.LittleEndian.Uint32(data[13:])
+		l := int(binary.LittleEndian.Uint16(data[17:]))
+		data = data[arqHeaderLen:]
+		if len(data) < l {
+			return errors.New("invalid segment length")
+		}
+
+		a.rmtWnd = wnd
+		a.parseUna(una)
+		a.shrinkBuf()
+
+		switch cmd {
+		case arqCmdAck:
+			if rtt := arqDiff(current, ts); rtt >= 0 {
+				a.updateRTT(rtt)
+			}
+			a.parseAck(sn)
+			a.shrinkBuf()
+			if !acked || arqDiff(sn, maxack) > 0 {
+				acked = true
+				maxack = sn
+			}
+		case arqCmdPush:
+			if arqDiff(sn, a.rcvNxt+arqRcvWnd) < 0 {
+				a.acks = append(a.acks, arqAck{sn, ts})
+				if arqDiff(sn, a.rcvNxt) >= 0 {
+					seg := &arqSegment{cmd: cmd, frg: frg, ts: ts, sn: sn}
+					seg.data = append([]byte(nil), data[:l]...)
+					a.parseData(seg)
+				}
+			}
+		case arqCmdPing:
+		case arqCmdSyn, arqCmdSynAck, arqCmdFin:
+			ctrl(cmd)
+		default:
+			return errors.New("invalid cmd")
+		}
+		data = data[l:]
+	}
+
+	if acked {
+		a.parseFastack(maxack)
+	}
+	return nil
+}
+
+func (a *arq) encode(seg *arqSegment, wnd uint16) {
+	if len(a.buf)+arqHeaderLen+len(seg.data) > arqMTU {
+		a.output(a.buf)
+		a.buf = a.buf[:arqConvLen]
+	}
+
+	var h [arqHeaderLen]byte
+	h[0] = seg.cmd
+	binary.LittleEndian.PutUint16(h[1:], seg.frg)
+	binary.LittleEndian.PutUint16(h[3:], wnd)
+	binary.LittleEndian.PutUint32(h[5:], seg.ts)
+	binary.LittleEndian.PutUint32(h[9:], seg.sn)
+	binary.LittleEndian.PutUint32(h[13:], a.rcvNxt)
+	binary.LittleEndian.PutUint16(h[17:], uint16(len(seg.data)))
+	a.buf = append(a.buf, h[:]...)
+	a.buf = append(a.buf, seg.data...)
+}
+
+// control queues a control segment, sent by the next flush
+func (a *arq) control(cmd uint8) {
+	a.ctrls = append(a.ctrls, cmd)
+}
+
+// flush sends the acks, the control segments, the new segments
+// within the window and the segments to send again
+func (a *arq) flush() {
+	current := arqNow()
+	wnd := a.wnd()
+
+	for _, ack := range a.acks {
+		a.encode(&arqSegment{cmd: arqCmdAck, sn: ack.sn, ts: ack.ts}, wnd)
+	}
+	a.acks = a.acks[:0]
+	for _, cmd := range a.ctrls {
+		a.encode(&arqSegment{cmd: cmd, ts: current}, wnd)
+	}
+	a.ctrls = a.ctrls[:0]
+
+	cwnd := uint32(arqSndWnd)
+	if uint32(a.rmtWnd) < cwnd {
+		cwnd = uint32(a.rmtWnd)
+	}
+	if cwnd == 0 {
+		// probes the window of the peer
+		cwnd = 1
+	}
+	n := 0
+	for n < len(a.sndQueue) && arqDiff(a.sndNxt, a.sndUna+cwnd) < 0 {
+		seg := a.sndQueue[n]
+		a.sndQueue[n] = nil
+		seg.sn = a.sndNxt
+		a.sndNxt++
+		a.sndBuf = append(a.sndBuf, seg)
+		n++
+	}
+	a.sndQueue = a.sndQueue[n:]
+
+	for _, seg := range a.sndBuf {
+		send := false
+		switch {
+		case seg.xmit == 0:
+			send = true
+			seg.rto = a.rto
+		case arqDiff(current, seg.resendts) >= 0:
+			send = true
+			seg.rto += seg.rto / 2
+			if seg.rto > arqMaxRTO {
+				seg.rto = arqMaxRTO
+			}
+			a.retrans++
+		case seg.fastack >= arqFastResend:
+			send = true
+			seg.fastack = 0
+			a.retrans++
+		}
+		if !send {
+			continue
+		}
+
+		seg.xmit++
+		seg.ts = current
+		seg.resendts = current + seg.rto
+		a.encode(seg, wnd)
+		if seg.xmit >= arqDeadLink {
+			a.dead = true
+		}
+	}
+
+	if len(a.buf) > arqConvLen {
+		a.output(a.buf)
+		a.buf = a.buf[:arqConvLen]
+	}
+}
@@ -0,0 +1,225 @@
+package network
+
+import (
+	"errors"
+	"gitee.com/aarlin/leaflet/log"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+)
+
+type UDPClient struct {
+	sync.Mutex
+	Addr             string
+	ConnNum          int
+	ConnectInterval  time.Duration
+	PendingWriteNum  int
+	MaxMsgLen        uint32
+	HandshakeTimeout time.Duration
+	// the period of the acks and the retransmissions
+	Interval time.Duration
+	// peers silent for longer are disconnected
+	IdleTimeout   time.Duration
+	AutoReconnect bool
+	NewAgent      func(*UDPConn) Agent
+	conns         map[*UDPConn]struct{}
+	wg            sync.WaitGroup
+	closeFlag     bool
+}
+
+func (client *UDPClient) Start() {
+	client.init()
+
+	for i := 0; i < client.ConnNum; i++ {
+		client.wg.Add(1)
+		go client.connect()
+	}
+}
+
+func (client *UDPClient) init() {
+	client.Lock()
+	defer client.Unlock()
+
+	if client.ConnNum <= 0 {
+		client.ConnNum = 1
+		log.ReleaseF("invalid ConnNum, reset to %v", client.ConnNum)
+	}
+	if client.ConnectInterval <= 0 {
+		client.ConnectInterval = 3 * time.Second
+		log.ReleaseF("invalid ConnectInterval, reset to %v", client.ConnectInterval)
+	}
+	if client.PendingWriteNum <= 0 {
+		client.PendingWriteNum = 100
+		log.ReleaseF("invalid PendingWriteNum, reset to %v", client.PendingWriteNum)
+	}
+	if client.MaxMsgLen <= 0 {
+		client.MaxMsgLen = 4096
+		log.ReleaseF("invalid MaxMsgLen, reset to %v", client.MaxMsgLen)
+	}
+	if client.MaxMsgLen > arqMaxMsgLen {
+		client.MaxMsgLen = arqMaxMsgLen
+		log.ReleaseF("invalid MaxMsgLen, reset to %v", client.MaxMsgLen)
+	}
+	if client.HandshakeTimeout <= 0 {
+		client.HandshakeTimeout = 10 * time.Second
+		log.ReleaseF("invalid HandshakeTimeout, reset to %v", client.HandshakeTimeout)
+	}
+	if client.Interval <= 0 {
+		client.Interval = 10 * time.Millisecond
+		log.ReleaseF("invalid Interval, reset to %v", client.Interval)
+	}
+	if client.IdleTimeout <= 0 {
+		client.IdleTimeout = 15 * time.Second
+		log.ReleaseF("invalid IdleTimeout, reset to %v", client.IdleTimeout)
+	}
+	if client.NewAgent == nil {
+		log.Fatal("NewAgent must not be nil")
+	}
+	if client.conns != nil {
+		log.Fatal("client is running")
+	}
+
+	client.conns = make(map[*UDPConn]struct{})
+	client.closeFlag = false
+}
+
+func (client *UDPClient) dial() *UDPConn {
+	for {
+		conn, err := client.handshake()
+		client.Lock()
+		closeFlag := client.closeFlag
+		client.Unlock()
+		if err == nil || closeFlag {
+			return conn
+		}
+
+		log.ReleaseF("connect to %v error: %v", client.Addr, err)
+		time.Sleep(client.ConnectInterval)
+		continue
+	}
+}
+
+func (client *UDPClient) handshake() (*UDPConn, error) {
+	addr, err := net.ResolveUDPAddr("udp", client.Addr)
+	if err != nil {
+		return nil, err
+	}
+	c, err := net.DialUDP("udp", nil, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	conn := newUDPConn(rand.Uint32(), c.LocalAddr(), c.RemoteAddr(), client.Interval,
+		client.PendingWriteNum, client.MaxMsgLen, client.IdleTimeout, func(b []byte) {
+			c.Write(b)
+		})
+	closeSig := make(chan struct{})
+	conn.onDestroy = func() {
+		close(closeSig)
+		c.Close()
+	}
+
+	// read
+	go func() {
+		buf := make([]byte, 64*1024)
+		for {
+			n, err := c.Read(buf)
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			if err != nil {
+				// no server yet
+				time.Sleep(client.Interval)
+				continue
+			}
+			conn.input(buf[:n])
+		}
+	}()
+
+	// update
+	go func() {
+		ticker := time.NewTicker(client.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-closeSig:
+				return
+			case <-ticker.C:
+				conn.update()
+			}
+		}
+	}()
+
+	// syn until synack
+	deadline := time.Now().Add(client.HandshakeTimeout)
+	for i := 0; time.Now().Before(deadline); i++ {
+		conn.Lock()
+		established, destroyed := conn.established, conn.destroyed
+		if !established && !destroyed && i%20 == 0 {
+			conn.arq.control(arqCmdSyn)
+			conn.arq.flush()
+		}
+		conn.Unlock()
+
+		if established {
+			return conn, nil
+		}
+		if destroyed {
+			return nil, errors.New("connection refused")
+		}
+		time.Sleep(client.Interval)
+	}
+	conn.Destroy()
+	return nil, errors.New("handshake timeout")
+}
+
+func (client *UDPClient) connect() {
+	defer client.wg.Done()
+
+reconnect:
+	conn := client.dial()
+	if conn == nil {
+		return
+	}
+
+	client.Lock()
+	if client.closeFlag {
+		client.Unlock()
+		conn.Destroy()
+		return
+	}
+	client.conns[conn] = struct{}{}
+	client.Unlock()
+
+	agent := client.NewAgent(conn)
+	agent.Run()
+
+	// cleanup
+	conn.Close()
+	client.Lock()
+	delete(client.conns, conn)
+	client.Unlock()
+	agent.OnClose()
+
+	client.Lock()
+	closeFlag := client.closeFlag
+	client.Unlock()
+
+	if client.AutoReconnect && !closeFlag {
+		time.Sleep(client.ConnectInterval)
+		goto reconnect
+	}
+}
+
+func (client *UDPClient) Close() {
+	client.Lock()
+	client.closeFlag = true
+	for conn := range client.conns {
+		conn.Destroy()
+	}
+	client.conns = nil
+	client.Unlock()
+
+	client.wg.Wait()
+}
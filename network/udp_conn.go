@@ -0,0 +1,230 @@
+package network
+
+import (
+	"errors"
+	"gitee.com/aarlin/leaflet/log"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// UDPConn is a reliable and ordered message stream over UDP
+type UDPConn struct {
+	sync.Mutex
+	arq             *arq
+	readCond        *sync.Cond
+	localAddr       net.Addr
+	remoteAddr      net.Addr
+	maxMsgLen       uint32
+	pendingWriteNum int
+	idleTimeout     time.Duration
+	lastRecv        time.Time
+	lastSend        time.Time
+	// the handshake is done
+	established bool
+	// no more writes, the pending ones are still sent
+	closeFlag bool
+	// gone, after a fin, a timeout or Destroy
+	destroyed bool
+	write     func(b []byte)
+	onDestroy func()
+}
+
+func newUDPConn(conv uint32, localAddr, remoteAddr net.Addr, interval time.Duration,
+	pendingWriteNum int, maxMsgLen uint32, idleTimeout time.Duration, write func(b []byte)) *UDPConn {
+	udpConn := new(UDPConn)
+	udpConn.readCond = sync.NewCond(&udpConn.Mutex)
+	udpConn.localAddr = localAddr
+	udpConn.remoteAddr = remoteAddr
+	udpConn.maxMsgLen = maxMsgLen
+	udpConn.pendingWriteNum = pendingWriteNum
+	udpConn.idleTimeout = idleTimeout
+	udpConn.lastRecv = time.Now()
+	udpConn.lastSend = udpConn.lastRecv
+	udpConn.write = write
+	udpConn.arq = newARQ(conv, interval, func(b []byte) {
+		udpConn.lastSend = time.Now()
+		udpWriteBytes.Add(uint64(len(b)))
+		udpConn.write(b)
+	})
+	return udpConn
+}
+
+// input handles a datagram of the peer
+func (udpConn *UDPConn) input(b []byte) {
+	udpConn.Lock()
+	defer udpConn.Unlock()
+	if udpConn.destroyed {
+		return
+	}
+
+	udpReadBytes.Add(uint64(len(b)))
+	udpConn.lastRecv = time.Now()
+	fin := false
+	err := udpConn.arq.input(b, func(cmd uint8) {
+		switch cmd {
+		case arqCmdSyn:
+			// the synack was lost
+			udpConn.arq.control(arqCmdSynAck)
+		case arqCmdSynAck:
+			udpConn.established = true
+		case arqCmdFin:
+			fin = true
+		}
+	})
+	if err != nil {
+		log.DebugF("udp input from %v: %v", udpConn.remoteAddr, err)
+		return
+	}
+
+	udpConn.readCond.Broadcast()
+	if fin {
+		log.DebugF("close conn %v: closed by peer", udpConn.remoteAddr)
+		udpConn.doDestroy(false)
+	}
+}
+
+// update runs every interval
+func (udpConn *UDPConn) update() {
+	udpConn.Lock()
+	defer udpConn.Unlock()
+	if udpConn.destroyed {
+		return
+	}
+
+	now := time.Now()
+	if now.Sub(udpConn.lastRecv) > udpConn.idleTimeout {
+		log.DebugF("close conn %v: idle timeout", udpConn.remoteAddr)
+		udpConn.doDestroy(true)
+		return
+	}
+	if now.Sub(udpConn.lastSend) > udpConn.idleTimeout/3 {
+		udpConn.arq.control(arqCmdPing)
+	}
+
+	retrans := udpConn.arq.retrans
+	udpConn.arq.flush()
+	udpRetrans.Add(udpConn.arq.retrans - retrans)
+	if udpConn.arq.dead {
+		log.DebugF("close conn %v: dead link", udpConn.remoteAddr)
+		udpConn.doDestroy(true)
+		return
+	}
+	if udpConn.closeFlag && udpConn.arq.waitSnd() == 0 {
+		udpConn.doDestroy(true)
+	}
+}
+
+func (udpConn *UDPConn) doDestroy(fin bool) {
+	if udpConn.destroyed {
+		return
+	}
+	if fin {
+		udpConn.arq.control(arqCmdFin)
+		udpConn.arq.flush()
+	}
+
+	udpConn.destroyed = true
+	udpConn.closeFlag = true
+	udpConn.readCond.Broadcast()
+	if udpConn.onDestroy != nil {
+		udpConn.onDestroy()
+	}
+}
+
+func (udpConn *UDPConn) Destroy() {
+	udpConn.Lock()
+	defer udpConn.Unlock()
+
+	udpConn.doDestroy(true)
+}
+
+// Close sends the pending messages, then closes the conn
+func (udpConn *UDPConn) Close() {
+	udpConn.Lock()
+	defer udpConn.Unlock()
+
+	udpConn.closeFlag = true
+}
+
+// goroutine not safe
+func (udpConn *UDPConn) ReadMsg() ([]byte, error) {
+	udpConn.Lock()
+	defer udpConn.Unlock()
+
+	for {
+		if msg, ok := udpConn.arq.recv(); ok {
+			return msg, nil
+		}
+		if udpConn.destroyed {
+			return nil, io.EOF
+		}
+		udpConn.readCond.Wait()
+	}
+}
+
+// WriteMsg returns ErrWriteFull when the messages waiting fill
+// PendingWriteNum, the conn is destroyed
+//
+// args must not be modified by the others goroutines
+func (udpConn *UDPConn) WriteMsg(args ...[]byte) error {
+	udpConn.Lock()
+	defer udpConn.Unlock()
+	if udpConn.closeFlag {
+		return nil
+	}
+
+	// get len
+	var msgLen uint32
+	for i := 0; i < len(args); i++ {
+		msgLen += uint32(len(args[i]))
+	}
+
+	// check len
+	if msgLen > udpConn.maxMsgLen {
+		return errors.New("message too long")
+	} else if msgLen < 1 {
+		return errors.New("message too short")
+	}
+
+	if len(udpConn.arq.sndQueue) >= udpConn.pendingWriteNum {
+		log.DebugF("close conn %v: %v", udpConn.remoteAddr, ErrWriteFull)
+		udpWriteFull.Inc()
+		udpConn.doDestroy(true)
+		return ErrWriteFull
+	}
+
+	// merge the args, the arq copies them
+	msg := args[0]
+	if len(args) > 1 {
+		msg = make([]byte, 0, msgLen)
+		for i := 0; i < len(args); i++ {
+			msg = append(msg, args[i]...)
+		}
+	}
+	if err := udpConn.arq.send(msg); err != nil {
+		return err
+	}
+
+	// sends at once rather than on the next update
+	udpConn.arq.flush()
+	return nil
+}
+
+// the conn is message based, use ReadMsg
+func (udpConn *UDPConn) Read(p []byte) (int, error) {
+	return 0, errors.New("udp conn: use ReadMsg")
+}
+
+func (udpConn *UDPConn) Write(p []byte) {
+	udpConn.WriteMsg(p)
+}
+
+func (udpConn *UDPConn) LocalAddr() net.Addr {
+	return udpConn.localAddr
+}
+
+func (udpConn *UDPConn) RemoteAddr() net.Addr {
+	return udpConn.remoteAddr
+}
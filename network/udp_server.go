@@ -0,0 +1,235 @@
+package network
+
+import (
+	"encoding/binary"
+	"gitee.com/aarlin/leaflet/log"
+	"gitee.com/aarlin/leaflet/metrics"
+	"net"
+	"sync"
+	"time"
+)
+
+type udpKey struct {
+	addr string
+	conv uint32
+}
+
+type UDPServer struct {
+	Addr            string
+	MaxConnNum      int
+	PendingWriteNum int
+	MaxMsgLen       uint32
+	// the period of the acks and the retransmissions
+	Interval time.Duration
+	// peers silent for longer are disconnected
+	IdleTimeout time.Duration
+	NewAgent    func(*UDPConn) Agent
+	pc          net.PacketConn
+	conns       map[udpKey]*UDPConn
+	accepting   bool
+	mutexConns  sync.Mutex
+	wgLn        sync.WaitGroup
+	wgConns     sync.WaitGroup
+	closeSig    chan struct{}
+	connsGauge  *metrics.Gauge
+	rejected    *metrics.Counter
+}
+
+func (server *UDPServer) Start() {
+	server.init()
+	server.wgLn.Add(2)
+	go server.run()
+	go server.update()
+}
+
+func (server *UDPServer) init() {
+	pc, err := net.ListenPacket("udp", server.Addr)
+	if err != nil {
+		log.FatalF("%v", err)
+	}
+
+	if server.MaxConnNum <= 0 {
+		server.MaxConnNum = 100
+		log.ReleaseF("invalid MaxConnNum, reset to %v", server.MaxConnNum)
+	}
+	if server.PendingWriteNum <= 0 {
+		server.PendingWriteNum = 100
+		log.ReleaseF("invalid PendingWriteNum, reset to %v", server.PendingWriteNum)
+	}
+	if server.MaxMsgLen <= 0 {
+		server.MaxMsgLen = 4096
+		log.ReleaseF("invalid MaxMsgLen, reset to %v", server.MaxMsgLen)
+	}
+	if server.MaxMsgLen > arqMaxMsgLen {
+		server.MaxMsgLen = arqMaxMsgLen
+		log.ReleaseF("invalid MaxMsgLen, reset to %v", server.MaxMsgLen)
+	}
+	if server.Interval <= 0 {
+		server.Interval = 10 * time.Millisecond
+		log.ReleaseF("invalid Interval, reset to %v", server.Interval)
+	}
+	if server.IdleTimeout <= 0 {
+		server.IdleTimeout = 15 * time.Second
+		log.ReleaseF("invalid IdleTimeout, reset to %v", server.IdleTimeout)
+	}
+	if server.NewAgent == nil {
+		log.Fatal("NewAgent must not be nil")
+	}
+
+	server.pc = pc
+	server.conns = make(map[udpKey]*UDPConn)
+	server.accepting = true
+	server.closeSig = make(chan struct{})
+	server.connsGauge = connsGauge("udp", server.Addr)
+	server.rejected = rejectedCounter("udp", server.Addr)
+}
+
+// LocalAddr returns the address the server listens on
+func (server *UDPServer) LocalAddr() net.Addr {
+	return server.pc.LocalAddr()
+}
+
+// a datagram of a single control segment
+func arqControlPacket(conv uint32, cmd uint8) []byte {
+	b := make([]byte, arqConvLen+arqHeaderLen)
+	binary.LittleEndian.PutUint32(b, conv)
+	b[arqConvLen] = cmd
+	return b
+}
+
+func (server *UDPServer) run() {
+	defer server.wgLn.Done()
+
+	buf := make([]byte, 64*1024)
+	for {
+		n, addr, err := server.pc.ReadFrom(buf)
+		if err != nil {
+			select {
+			case <-server.closeSig:
+				return
+			default:
+			}
+			log.DebugF("udp read error: %v", err)
+			continue
+		}
+		if n < arqConvLen+arqHeaderLen {
+			continue
+		}
+
+		conv := binary.LittleEndian.Uint32(buf)
+		key := udpKey{addr.String(), conv}
+		server.mutexConns.Lock()
+		conn := server.conns[key]
+		if conn == nil {
+			cmd := buf[arqConvLen]
+			if cmd != arqCmdSyn || !server.accepting {
+				server.mutexConns.Unlock()
+				// the session is gone, tells the peer
+				if cmd != arqCmdFin {
+					server.pc.WriteTo(arqControlPacket(conv, arqCmdFin), addr)
+				}
+				continue
+			}
+			if len(server.conns) >= server.MaxConnNum {
+				server.mutexConns.Unlock()
+				server.pc.WriteTo(arqControlPacket(conv, arqCmdFin), addr)
+				server.rejected.Inc()
+				log.Debug("too many connections")
+				continue
+			}
+			conn = server.newConn(key, conv, addr)
+			server.conns[key] = conn
+			server.mutexConns.Unlock()
+			server.connsGauge.Inc()
+
+			server.wgConns.Add(1)
+			agent := server.NewAgent(conn)
+			go func() {
+				agent.Run()
+
+				// cleanup
+				conn.Close()
+				agent.OnClose()
+
+				server.wgConns.Done()
+			}()
+		} else {
+			server.mutexConns.Unlock()
+		}
+
+		conn.input(buf[:n])
+	}
+}
+
+func (server *UDPServer) newConn(key udpKey, conv uint32, addr net.Addr) *UDPConn {
+	pc := server.pc
+	conn := newUDPConn(conv, pc.LocalAddr(), addr, server.Interval,
+		server.PendingWriteNum, server.MaxMsgLen, server.IdleTimeout, func(b []byte) {
+			pc.WriteTo(b, addr)
+		})
+	conn.established = true
+	conn.onDestroy = func() {
+		server.mutexConns.Lock()
+		if server.conns[key] == conn {
+			delete(server.conns, key)
+			server.connsGauge.Dec()
+		}
+		server.mutexConns.Unlock()
+	}
+	return conn
+}
+
+func (server *UDPServer) update() {
+	defer server.wgLn.Done()
+
+	ticker := time.NewTicker(server.Interval)
+	defer ticker.Stop()
+
+	var conns []*UDPConn
+	for {
+		select {
+		case <-server.closeSig:
+			return
+		case <-ticker.C:
+		}
+
+		server.mutexConns.Lock()
+		for _, conn := range server.conns {
+			conns = append(conns, conn)
+		}
+		server.mutexConns.Unlock()
+
+		for i, conn := range conns {
+			conn.update()
+			conns[i] = nil
+		}
+		conns = conns[:0]
+	}
+}
+
+// StopAccept refuses the new sessions and keeps the connections,
+// which share the socket of the server
+func (server *UDPServer) StopAccept() {
+	server.mutexConns.Lock()
+	server.accepting = false
+	server.mutexConns.Unlock()
+}
+
+func (server *UDPServer) Close() {
+	server.mutexConns.Lock()
+	server.accepting = false
+	conns := make([]*UDPConn, 0, len(server.conns))
+	for _, conn := range server.conns {
+		conns = append(conns, conn)
+	}
+	server.mutexConns.Unlock()
+
+	for _, conn := range conns {
+		conn.Destroy()
+	}
+	server.wgConns.Wait()
+
+	close(server.closeSig)
+	server.pc.Close()
+	server.wgLn.Wait()
+}
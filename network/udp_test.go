@@ -0,0 +1,225 @@
+package network_test
+
+import (
+	"bytes"
+	"fmt"
+	"gitee.com/aarlin/leaflet/network"
+	"math/rand"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// lossyProxy forwards the datagrams of one client to the server,
+// dropping some of them both ways
+type lossyProxy struct {
+	pc     net.PacketConn
+	server net.Addr
+	loss   float64
+
+	mutex  sync.Mutex
+	client net.Addr
+	rand   *rand.Rand
+}
+
+func newLossyProxy(t *testing.T, server net.Addr, loss float64) *lossyProxy {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &lossyProxy{pc: pc, server: server, loss: loss, rand: rand.New(rand.NewSource(1))}
+	go p.run()
+	return p
+}
+
+func (p *lossyProxy) run() {
+	buf := make([]byte, 64*1024)
+	for {
+		n, addr, err := p.pc.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+
+		p.mutex.Lock()
+		drop := p.rand.Float64() < p.loss
+		to := p.server
+		if addr.String() == p.server.String() {
+			to = p.client
+		} else {
+			p.client = addr
+		}
+		p.mutex.Unlock()
+
+		if !drop && to != nil {
+			p.pc.WriteTo(buf[:n], to)
+		}
+	}
+}
+
+type echoAgent struct {
+	conn   *network.UDPConn
+	closed chan bool
+}
+
+func (a *echoAgent) Run() {
+	for {
+		msg, err := a.conn.ReadMsg()
+		if err != nil {
+			return
+		}
+		a.conn.WriteMsg(msg)
+	}
+}
+
+func (a *echoAgent) OnClose() {
+	a.closed <- true
+}
+
+type checkAgent struct {
+	conn *network.UDPConn
+	msgs [][]byte
+	done chan error
+}
+
+func (a *checkAgent) Run() {
+	for _, msg := range a.msgs {
+		if err := a.conn.WriteMsg(msg[:len(msg)/2], msg[len(msg)/2:]); err != nil {
+			a.done <- err
+			return
+		}
+	}
+	for i, msg := range a.msgs {
+		echo, err := a.conn.ReadMsg()
+		if err != nil {
+			a.done <- err
+			return
+		}
+		if !bytes.Equal(echo, msg) {
+			a.done <- fmt.Errorf("message %v: echo mismatch", i)
+			return
+		}
+	}
+	a.done <- nil
+}
+
+func (a *checkAgent) OnClose() {}
+
+func TestUDPLoss(t *testing.T) {
+	closed := make(chan bool, 1)
+	server := &network.UDPServer{
+		Addr:            "127.0.0.1:0",
+		MaxConnNum:      10,
+		PendingWriteNum: 1000,
+		MaxMsgLen:       8192,
+		// the fin of the client may be lost
+		IdleTimeout: 3 * time.Second,
+		NewAgent: func(conn *network.UDPConn) network.Agent {
+			return &echoAgent{conn: conn, closed: closed}
+		},
+	}
+	server.Start()
+	defer server.Close()
+
+	proxy := newLossyProxy(t, server.LocalAddr(), 0.2)
+	defer proxy.pc.Close()
+
+	// small messages and some split into several segments
+	r := rand.New(rand.NewSource(2))
+	msgs := make([][]byte, 300)
+	for i := range msgs {
+		size := 2 + r.Intn(100)
+		if i%10 == 0 {
+			size = 2 + r.Intn(8000)
+		}
+		msgs[i] = make([]byte, size)
+		r.Read(msgs[i])
+	}
+
+	done := make(chan error, 1)
+	client := &network.UDPClient{
+		Addr:             proxy.pc.LocalAddr().String(),
+		PendingWriteNum:  1000,
+		MaxMsgLen:        8192,
+		HandshakeTimeout: 5 * time.Second,
+		NewAgent: func(conn *network.UDPConn) network.Agent {
+			return &checkAgent{conn: conn, msgs: msgs, done: done}
+		},
+	}
+	client.Start()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(30 * time.Second):
+		t.Fatal("timeout")
+	}
+
+	// the server side ends once the client is gone
+	client.Close()
+	select {
+	case <-closed:
+	case <-time.After(10 * time.Second):
+		t.Fatal("server agent not closed")
+	}
+}
+
+type idleAgent struct {
+	done chan struct{}
+}
+
+func (a *idleAgent) Run() {
+	// holds the messages unread, the window of the peer closes
+	<-a.done
+}
+
+func (a *idleAgent) OnClose() {}
+
+func TestUDPWriteFull(t *testing.T) {
+	done := make(chan struct{})
+	written := make(chan error, 1)
+	server := &network.UDPServer{
+		Addr:            "127.0.0.1:0",
+		MaxConnNum:      10,
+		PendingWriteNum: 10,
+		MaxMsgLen:       4096,
+		NewAgent: func(conn *network.UDPConn) network.Agent {
+			go func() {
+				for i := 0; i < 10000; i++ {
+					if err := conn.WriteMsg([]byte("hello")); err != nil {
+						written <- err
+						return
+					}
+				}
+				written <- nil
+			}()
+			return &idleAgent{done: done}
+		},
+	}
+	server.Start()
+	defer server.Close()
+
+	client := &network.UDPClient{
+		Addr:             server.LocalAddr().String(),
+		PendingWriteNum:  10,
+		MaxMsgLen:        4096,
+		HandshakeTimeout: 5 * time.Second,
+		NewAgent: func(conn *network.UDPConn) network.Agent {
+			return &idleAgent{done: done}
+		},
+	}
+	client.Start()
+	defer client.Close()
+	defer close(done)
+
+	select {
+	case err := <-written:
+		if err != network.ErrWriteFull {
+			t.Fatalf("WriteMsg error %v, want ErrWriteFull", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("timeout")
+	}
+}
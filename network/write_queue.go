@@ -0,0 +1,196 @@
+package network
+
+import (
+	"errors"
+	"gitee.com/aarlin/leaflet/log"
+	"sync"
+	"time"
+)
+
+// OverflowPolicy is what a conn does when PendingWriteNum messages are
+// waiting to be written
+type OverflowPolicy int
+
+const (
+	// the conn is destroyed, WriteMsg returns ErrWriteFull
+	OverflowDisconnect OverflowPolicy = iota
+	// WriteMsg waits for room up to WriteBlockTimeout, then the message is
+	// dropped and WriteMsg returns ErrWriteTimeout
+	OverflowBlock
+	// the oldest waiting message is dropped
+	OverflowDropOldest
+	// the waiting message of the lowest priority makes room for a message
+	// of a higher one, else the message is dropped and WriteMsg returns
+	// ErrMsgDropped
+	OverflowDropPriority
+)
+
+func (p OverflowPolicy) String() string {
+	switch p {
+	case OverflowDisconnect:
+		return "disconnect"
+	case OverflowBlock:
+		return "block"
+	case OverflowDropOldest:
+		return "drop_oldest"
+	case OverflowDropPriority:
+		return "drop_priority"
+	}
+	return "unknown"
+}
+
+var (
+	ErrWriteFull    = errors.New("write queue full, conn closed")
+	ErrWriteTimeout = errors.New("write queue full, timeout")
+	ErrMsgDropped   = errors.New("write queue full, message dropped")
+)
+
+// writePolicy is the part of the config of the servers about the overflow
+type writePolicy struct {
+	policy       OverflowPolicy
+	blockTimeout time.Duration
+	priority     func(args [][]byte) int
+}
+
+func newWritePolicy(policy OverflowPolicy, blockTimeout time.Duration, priority func(args [][]byte) int) writePolicy {
+	if policy == OverflowBlock && blockTimeout <= 0 {
+		blockTimeout = time.Second
+		log.ReleaseF("invalid WriteBlockTimeout, reset to %v", blockTimeout)
+	}
+	if policy == OverflowDropPriority && priority == nil {
+		log.Fatal("WritePriority must not be nil")
+	}
+	return writePolicy{policy: policy, blockTimeout: blockTimeout, priority: priority}
+}
+
+// priorityOf is 0 without WritePriority
+func (p *writePolicy) priorityOf(args [][]byte) int {
+	if p.priority == nil {
+		return 0
+	}
+	return p.priority(args)
+}
+
+// writeQueue holds the messages waiting for the writer goroutine of a conn
+type writeQueue struct {
+	sync.Mutex
+	cond   *sync.Cond
+	bufs   [][]byte
+	prios  []int
+	max    int
+	policy writePolicy
+	// no more messages, the writer drains the queue
+	closed bool
+	// the writer exits at once
+	destroyed bool
+}
+
+func newWriteQueue(max int, policy writePolicy) *writeQueue {
+	q := &writeQueue{max: max, policy: policy}
+	q.cond = sync.NewCond(&q.Mutex)
+	return q
+}
+
+// push queues b by the policy, ErrWriteFull asks the caller to destroy
+// the conn
+//
+// goroutine safe
+func (q *writeQueue) push(b []byte, prio int) error {
+	q.Lock()
+	defer q.Unlock()
+	if q.closed || q.destroyed {
+		return nil
+	}
+
+	if len(q.bufs) >= q.max {
+		switch q.policy.policy {
+		case OverflowBlock:
+			deadline := time.Now().Add(q.policy.blockTimeout)
+			t := time.AfterFunc(q.policy.blockTimeout, func() {
+				q.Lock()
+				q.cond.Broadcast()
+				q.Unlock()
+			})
+			for len(q.bufs) >= q.max && !q.closed && !q.destroyed && time.Now().Before(deadline) {
+				q.cond.Wait()
+			}
+			t.Stop()
+			if q.closed || q.destroyed {
+				return nil
+			}
+			if len(q.bufs) >= q.max {
+				return ErrWriteTimeout
+			}
+		case OverflowDropOldest:
+			q.drop(0)
+		case OverflowDropPriority:
+			lowest := 0
+			for i := 1; i < len(q.prios); i++ {
+				if q.prios[i] < q.prios[lowest] {
+					lowest = i
+				}
+			}
+			if q.prios[lowest] >= prio {
+				return ErrMsgDropped
+			}
+			q.drop(lowest)
+		default:
+			return ErrWriteFull
+		}
+	}
+
+	q.bufs = append(q.bufs, b)
+	q.prios = append(q.prios, prio)
+	q.cond.Broadcast()
+	return nil
+}
+
+func (q *writeQueue) drop(i int) {
+	copy(q.bufs[i:], q.bufs[i+1:])
+	q.bufs[len(q.bufs)-1] = nil
+	q.bufs = q.bufs[:len(q.bufs)-1]
+	copy(q.prios[i:], q.prios[i+1:])
+	q.prios = q.prios[:len(q.prios)-1]
+}
+
+// pop waits for the messages and takes them all, false once the writer
+// should exit
+func (q *writeQueue) pop(batch [][]byte) ([][]byte, bool) {
+	q.Lock()
+	defer q.Unlock()
+
+	for len(q.bufs) == 0 && !q.closed && !q.destroyed {
+		q.cond.Wait()
+	}
+	if q.destroyed || len(q.bufs) == 0 {
+		return nil, false
+	}
+
+	batch = append(batch[:0], q.bufs...)
+	for i := range q.bufs {
+		q.bufs[i] = nil
+	}
+	q.bufs = q.bufs[:0]
+	q.prios = q.prios[:0]
+	// room for the blocked writers
+	q.cond.Broadcast()
+	return batch, true
+}
+
+// close lets the writer send the waiting messages, then exit
+func (q *writeQueue) close() {
+	q.Lock()
+	q.closed = true
+	q.cond.Broadcast()
+	q.Unlock()
+}
+
+// destroy drops the waiting messages
+func (q *writeQueue) destroy() {
+	q.Lock()
+	q.destroyed = true
+	q.bufs = nil
+	q.prios = nil
+	q.cond.Broadcast()
+	q.Unlock()
+}
@@ -0,0 +1,78 @@
+package network
+
+import (
+	"testing"
+	"time"
+)
+
+func fill(t *testing.T, q *writeQueue, prios ...int) {
+	for i, prio := range prios {
+		if err := q.push([]byte{byte(i)}, prio); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func popped(q *writeQueue) []byte {
+	batch, _ := q.pop(nil)
+	var b []byte
+	for _, buf := range batch {
+		b = append(b, buf...)
+	}
+	return b
+}
+
+func TestWriteQueueOverflow(t *testing.T) {
+	q := newWriteQueue(2, newWritePolicy(OverflowDisconnect, 0, nil))
+	fill(t, q, 0, 0)
+	if err := q.push([]byte{2}, 0); err != ErrWriteFull {
+		t.Fatalf("disconnect: %v", err)
+	}
+
+	q = newWriteQueue(2, newWritePolicy(OverflowDropOldest, 0, nil))
+	fill(t, q, 0, 0, 0)
+	if b := popped(q); string(b) != "\x01\x02" {
+		t.Fatalf("drop oldest: %v", b)
+	}
+
+	// drops the lowest class, the oldest of it first
+	q = newWriteQueue(3, newWritePolicy(OverflowDropPriority, 0, func([][]byte) int { return 0 }))
+	fill(t, q, 1, 0, 0)
+	if err := q.push([]byte{3}, 0); err != ErrMsgDropped {
+		t.Fatalf("drop priority: %v", err)
+	}
+	if err := q.push([]byte{4}, 2); err != nil {
+		t.Fatal(err)
+	}
+	if b := popped(q); string(b) != "\x00\x02\x04" {
+		t.Fatalf("drop priority: %v", b)
+	}
+
+	q = newWriteQueue(1, newWritePolicy(OverflowBlock, 50*time.Millisecond, nil))
+	fill(t, q, 0)
+	start := time.Now()
+	if err := q.push([]byte{1}, 0); err != ErrWriteTimeout {
+		t.Fatalf("block: %v", err)
+	}
+	if time.Since(start) < 50*time.Millisecond {
+		t.Fatal("block: no wait")
+	}
+
+	// the writer makes room
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		q.pop(nil)
+	}()
+	if err := q.push([]byte{1}, 0); err != nil {
+		t.Fatalf("block: %v", err)
+	}
+
+	// close drains the queue
+	q.close()
+	if b := popped(q); string(b) != "\x01" {
+		t.Fatalf("close: %v", b)
+	}
+	if _, ok := q.pop(nil); ok {
+		t.Fatal("pop after close")
+	}
+}
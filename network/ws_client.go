@@ -22,6 +22,13 @@ type WSClient struct {
 	wg               sync.WaitGroup
 	closeFlag        bool
 	ReadTimeOut      time.Duration
+
+	// when PendingWriteNum messages are waiting
+	OverflowPolicy    OverflowPolicy
+	WriteBlockTimeout time.Duration
+	// the priority class of a message for OverflowDropPriority
+	WritePriority func(args [][]byte) int
+	writePolicy   writePolicy
 }
 
 func (client *WSClient) Start() {
@@ -66,6 +73,7 @@ func (client *WSClient) init() {
 
 	client.conns = make(WebsocketConnSet)
 	client.closeFlag = false
+	client.writePolicy = newWritePolicy(client.OverflowPolicy, client.WriteBlockTimeout, client.WritePriority)
 	client.dialer = websocket.Dialer{
 		HandshakeTimeout: client.HandshakeTimeout,
 	}
@@ -103,7 +111,7 @@ reconnect:
 	client.conns[conn] = struct{}{}
 	client.Unlock()
 
-	wsConn := newWSConn(conn, client.PendingWriteNum, client.MaxMsgLen, client.ReadTimeOut)
+	wsConn := newWSConn(conn, client.PendingWriteNum, client.MaxMsgLen, client.ReadTimeOut, client.writePolicy)
 	agent := client.NewAgent(wsConn)
 	agent.Run()
 
@@ -13,33 +13,42 @@ type WebsocketConnSet map[*websocket.Conn]struct{}
 
 type WSConn struct {
 	sync.Mutex
-	conn      *websocket.Conn
-	writeChan chan []byte
-	maxMsgLen uint32
-	closeFlag bool
+	conn        *websocket.Conn
+	writeQueue  *writeQueue
+	maxMsgLen   uint32
+	closeFlag   bool
 	readTimeOut time.Duration
 }
 
-func newWSConn(conn *websocket.Conn, pendingWriteNum int, maxMsgLen uint32,readTimeOut time.Duration) *WSConn {
+func newWSConn(conn *websocket.Conn, pendingWriteNum int, maxMsgLen uint32,readTimeOut time.Duration, policy writePolicy) *WSConn {
 	wsConn := new(WSConn)
 	wsConn.conn = conn
-	wsConn.writeChan = make(chan []byte, pendingWriteNum)
+	wsConn.writeQueue = newWriteQueue(pendingWriteNum, policy)
 	wsConn.maxMsgLen = maxMsgLen
 	wsConn.readTimeOut = readTimeOut
 
 	go func() {
-		for b := range wsConn.writeChan {
-			if b == nil {
+		// a frame per message, the waiting ones taken at once
+		var batch [][]byte
+	write:
+		for {
+			var ok bool
+			batch, ok = wsConn.writeQueue.pop(batch)
+			if !ok {
 				break
 			}
 
-			err := conn.WriteMessage(websocket.BinaryMessage, b)
-			if err != nil {
-				break
+			for _, b := range batch {
+				err := conn.WriteMessage(websocket.BinaryMessage, b)
+				if err != nil {
+					break write
+				}
+				wsWriteBytes.Add(uint64(len(b)))
 			}
 		}
 
 		conn.Close()
+		wsConn.writeQueue.destroy()
 		wsConn.Lock()
 		wsConn.closeFlag = true
 		wsConn.Unlock()
@@ -51,11 +60,8 @@ func newWSConn(conn *websocket.Conn, pendingWriteNum int, maxMsgLen uint32,readT
 func (wsConn *WSConn) doDestroy() {
 	wsConn.conn.UnderlyingConn().(*net.TCPConn).SetLinger(0)
 	wsConn.conn.Close()
-
-	if !wsConn.closeFlag {
-		close(wsConn.writeChan)
-		wsConn.closeFlag = true
-	}
+	wsConn.writeQueue.destroy()
+	wsConn.closeFlag = true
 }
 
 func (wsConn *WSConn) Destroy() {
@@ -72,18 +78,24 @@ func (wsConn *WSConn) Close() {
 		return
 	}
 
-	wsConn.doWrite(nil)
+	wsConn.writeQueue.close()
 	wsConn.closeFlag = true
 }
 
-func (wsConn *WSConn) doWrite(b []byte) {
-	if len(wsConn.writeChan) == cap(wsConn.writeChan) {
-		log.Debug("close conn: channel full")
-		wsConn.doDestroy()
-		return
+// doWrite queues b by the overflow policy, it may wait with OverflowBlock
+func (wsConn *WSConn) doWrite(b []byte, prio int) error {
+	err := wsConn.writeQueue.push(b, prio)
+	if err == nil {
+		return nil
 	}
-
-	wsConn.writeChan <- b
+	if err == ErrWriteFull {
+		log.DebugF("close conn %v: %v", wsConn.RemoteAddr(), err)
+		wsWriteFull.Inc()
+		wsConn.Destroy()
+	} else {
+		wsWriteDropped.Inc()
+	}
+	return err
 }
 
 func (wsConn *WSConn) LocalAddr() net.Addr {
@@ -109,14 +121,19 @@ func (wsConn *WSConn) ReadMsg() ([]byte, error) {
 	}
 
 	_, b, err := wsConn.conn.ReadMessage()
+	wsReadBytes.Add(uint64(len(b)))
 	return b, err
 }
 
+// WriteMsg returns ErrWriteFull, ErrWriteTimeout or ErrMsgDropped when the
+// messages waiting fill PendingWriteNum, by the overflow policy
+//
 // args must not be modified by the others goroutines
 func (wsConn *WSConn) WriteMsg(args ...[]byte) error {
 	wsConn.Lock()
-	defer wsConn.Unlock()
-	if wsConn.closeFlag {
+	closeFlag := wsConn.closeFlag
+	wsConn.Unlock()
+	if closeFlag {
 		return nil
 	}
 
@@ -133,10 +150,11 @@ func (wsConn *WSConn) WriteMsg(args ...[]byte) error {
 		return errors.New("message too short")
 	}
 
+	prio := wsConn.writeQueue.policy.priorityOf(args)
+
 	// don't copy
 	if len(args) == 1 {
-		wsConn.doWrite(args[0])
-		return nil
+		return wsConn.doWrite(args[0], prio)
 	}
 
 	// merge the args
@@ -147,7 +165,5 @@ func (wsConn *WSConn) WriteMsg(args ...[]byte) error {
 		l += len(args[i])
 	}
 
-	wsConn.doWrite(msg)
-
-	return nil
+	return wsConn.doWrite(msg, prio)
 }
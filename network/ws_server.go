@@ -4,6 +4,7 @@ import (
 	"crypto/tls"
 	"github.com/gorilla/websocket"
 	"gitee.com/aarlin/leaflet/log"
+	"gitee.com/aarlin/leaflet/metrics"
 	"net"
 	"net/http"
 	"sync"
@@ -21,6 +22,12 @@ type WSServer struct {
 	NewAgent        func(*WSConn) Agent
 	ln              net.Listener
 	handler         *WSHandler
+
+	// when PendingWriteNum messages are waiting
+	OverflowPolicy    OverflowPolicy
+	WriteBlockTimeout time.Duration
+	// the priority class of a message for OverflowDropPriority
+	WritePriority func(args [][]byte) int
 }
 
 type WSHandler struct {
@@ -33,6 +40,9 @@ type WSHandler struct {
 	mutexConns      sync.Mutex
 	wg              sync.WaitGroup
 	ReadTimeout 	time.Duration
+	connsGauge      *metrics.Gauge
+	rejected        *metrics.Counter
+	writePolicy     writePolicy
 }
 
 func (handler *WSHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -59,13 +69,15 @@ func (handler *WSHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	if len(handler.conns) >= handler.maxConnNum {
 		handler.mutexConns.Unlock()
 		conn.Close()
+		handler.rejected.Inc()
 		log.Debug("too many connections")
 		return
 	}
 	handler.conns[conn] = struct{}{}
 	handler.mutexConns.Unlock()
+	handler.connsGauge.Inc()
 
-	wsConn := newWSConn(conn, handler.pendingWriteNum, handler.maxMsgLen, handler.ReadTimeout)
+	wsConn := newWSConn(conn, handler.pendingWriteNum, handler.maxMsgLen, handler.ReadTimeout, handler.writePolicy)
 	agent := handler.newAgent(wsConn)
 	agent.Run()
 
@@ -74,6 +86,7 @@ func (handler *WSHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	handler.mutexConns.Lock()
 	delete(handler.conns, conn)
 	handler.mutexConns.Unlock()
+	handler.connsGauge.Dec()
 	agent.OnClose()
 }
 
@@ -125,6 +138,9 @@ func (server *WSServer) Start() {
 		newAgent:        server.NewAgent,
 		ReadTimeout:     server.HTTPTimeout,
 		conns:           make(WebsocketConnSet),
+		connsGauge:      connsGauge("ws", server.Addr),
+		rejected:        rejectedCounter("ws", server.Addr),
+		writePolicy:     newWritePolicy(server.OverflowPolicy, server.WriteBlockTimeout, server.WritePriority),
 		upgrader: websocket.Upgrader{
 			HandshakeTimeout: server.HTTPTimeout,
 			CheckOrigin:      func(_ *http.Request) bool { return true },
@@ -142,6 +158,11 @@ func (server *WSServer) Start() {
 	go httpServer.Serve(ln)
 }
 
+// StopAccept closes the listener and keeps the connections
+func (server *WSServer) StopAccept() {
+	server.ln.Close()
+}
+
 func (server *WSServer) Close() {
 	server.ln.Close()
 
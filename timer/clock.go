@@ -0,0 +1,150 @@
+package timer
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+)
+
+// Clock tells the time and runs functions later, the dispatchers read
+// the time from their clock only
+type Clock interface {
+	Now() time.Time
+	AfterFunc(d time.Duration, f func()) ClockTimer
+}
+
+// ClockTimer is implemented by *time.Timer
+type ClockTimer interface {
+	// returns false if the timer has already fired or been stopped
+	Stop() bool
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) AfterFunc(d time.Duration, f func()) ClockTimer {
+	return time.AfterFunc(d, f)
+}
+
+// SystemClock is the clock of the time package, the default one
+var SystemClock Clock = systemClock{}
+
+// FakeClock only moves forward on Advance, which runs the functions
+// due meanwhile in the order of their times. A dispatcher of a fake
+// clock fires its timers on the goroutine calling Advance, which must
+// be the goroutine of the dispatcher
+//
+// goroutine safe
+type FakeClock struct {
+	mutex  sync.Mutex
+	now    time.Time
+	seq    uint64
+	timers fakeTimers
+}
+
+type fakeTimer struct {
+	clock *FakeClock
+	when  time.Time
+	// ties are run in the order added
+	seq   uint64
+	f     func()
+	index int
+}
+
+type fakeTimers []*fakeTimer
+
+func (h fakeTimers) Len() int {
+	return len(h)
+}
+
+func (h fakeTimers) Less(i, j int) bool {
+	if h[i].when.Equal(h[j].when) {
+		return h[i].seq < h[j].seq
+	}
+	return h[i].when.Before(h[j].when)
+}
+
+func (h fakeTimers) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *fakeTimers) Push(x interface{}) {
+	t := x.(*fakeTimer)
+	t.index = len(*h)
+	*h = append(*h, t)
+}
+
+func (h *fakeTimers) Pop() interface{} {
+	old := *h
+	t := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	t.index = -1
+	return t
+}
+
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now}
+}
+
+func (c *FakeClock) Now() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.now
+}
+
+func (c *FakeClock) AfterFunc(d time.Duration, f func()) ClockTimer {
+	if d < 0 {
+		d = 0
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.seq++
+	t := &fakeTimer{clock: c, when: c.now.Add(d), seq: c.seq, f: f}
+	heap.Push(&c.timers, t)
+	return t
+}
+
+func (t *fakeTimer) Stop() bool {
+	c := t.clock
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if t.index < 0 {
+		return false
+	}
+	heap.Remove(&c.timers, t.index)
+	return true
+}
+
+// Advance moves the clock forward by d, the functions due run with
+// the clock set to their time, those they add run too if due
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mutex.Lock()
+	target := c.now.Add(d)
+	for len(c.timers) > 0 && !c.timers[0].when.After(target) {
+		t := heap.Pop(&c.timers).(*fakeTimer)
+		if t.when.After(c.now) {
+			c.now = t.when
+		}
+		c.mutex.Unlock()
+		t.f()
+		c.mutex.Lock()
+	}
+	if target.After(c.now) {
+		c.now = target
+	}
+	c.mutex.Unlock()
+}
+
+// Pending returns the number of the functions not run yet
+func (c *FakeClock) Pending() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return len(c.timers)
+}
@@ -9,14 +9,32 @@ import (
 	"time"
 )
 
-// Field name   | Mandatory? | Allowed values | Allowed special characters
-// ----------   | ---------- | -------------- | --------------------------
-// Seconds      | No         | 0-59           | * / , -
-// Minutes      | Yes        | 0-59           | * / , -
-// Hours        | Yes        | 0-23           | * / , -
-// Day of month | Yes        | 1-31           | * / , -
-// Month        | Yes        | 1-12           | * / , -
-// Day of week  | Yes        | 0-6            | * / , -
+// Field name   | Mandatory? | Allowed values  | Allowed special characters
+// ----------   | ---------- | --------------  | --------------------------
+// Seconds      | No         | 0-59            | * / , -
+// Minutes      | Yes        | 0-59            | * / , -
+// Hours        | Yes        | 0-23            | * / , -
+// Day of month | Yes        | 1-31            | * / , - ? L W
+// Month        | Yes        | 1-12 or JAN-DEC | * / , -
+// Day of week  | Yes        | 0-7 or SUN-SAT  | * / , - ? L #
+//
+// 0 and 7 are both Sunday. L is the last day of the month, LW the last weekday of the month,
+// 15W the weekday nearest to the 15th in the same month, 5L the last
+// Friday of the month and 0#2 the second Sunday of the month
+//
+// Predefined schedules:
+// @yearly (or @annually) | 0 0 0 1 1 *
+// @monthly               | 0 0 0 1 * *
+// @weekly                | 0 0 0 * * 0
+// @daily (or @midnight)  | 0 0 0 * * *
+// @hourly                | 0 0 * * * *
+// @every <duration>      | every duration, at least 1s, from the given time
+//
+// the expression may start with TZ=<location> (or CRON_TZ=<location>),
+// the times are matched in that location, by default in the location
+// of the time given to Next.
+// A time skipped by DST runs after the gap, a time repeated by DST
+// runs once, unless the hours field is *
 type CronExpr struct {
 	sec   uint64
 	min   uint64
@@ -24,11 +42,94 @@ type CronExpr struct {
 	dom   uint64
 	month uint64
 	dow   uint64
+
+	// day of month: L, LW and nW
+	lastDom     bool
+	lastWeekDom bool
+	nearestDom  uint64
+	// day of week: nL and n#m
+	lastDow uint64
+	nthDow  [7]uint8
+
+	every time.Duration
+	loc   *time.Location
+	expr  string
 }
 
+const (
+	allDom   = 0xfffffffe
+	allDow   = 0x7f
+	allHours = 0xffffff
+)
+
+var (
+	monthNames = map[string]int{
+		"JAN": 1, "FEB": 2, "MAR": 3, "APR": 4, "MAY": 5, "JUN": 6,
+		"JUL": 7, "AUG": 8, "SEP": 9, "OCT": 10, "NOV": 11, "DEC": 12,
+	}
+	dowNames = map[string]int{
+		"SUN": 0, "MON": 1, "TUE": 2, "WED": 3, "THU": 4, "FRI": 5, "SAT": 6,
+	}
+	descriptors = map[string]string{
+		"@yearly":   "0 0 0 1 1 *",
+		"@annually": "0 0 0 1 1 *",
+		"@monthly":  "0 0 0 1 * *",
+		"@weekly":   "0 0 0 * * 0",
+		"@daily":    "0 0 0 * * *",
+		"@midnight": "0 0 0 * * *",
+		"@hourly":   "0 0 * * * *",
+	}
+)
+
 // goroutine safe
 func NewCronExpr(expr string) (cronExpr *CronExpr, err error) {
-	fields := strings.Fields(expr)
+	return NewCronExprIn(expr, nil)
+}
+
+// goroutine safe
+//
+// NewCronExprIn matches the times in loc unless expr starts with TZ=,
+// a nil loc is the location of the time given to Next
+func NewCronExprIn(expr string, loc *time.Location) (cronExpr *CronExpr, err error) {
+	spec := strings.TrimSpace(expr)
+	if strings.HasPrefix(spec, "TZ=") || strings.HasPrefix(spec, "CRON_TZ=") {
+		i := strings.IndexAny(spec, " \t")
+		if i < 0 {
+			err = fmt.Errorf("invalid expr %v: missing fields", expr)
+			return
+		}
+		name := spec[strings.Index(spec, "=")+1 : i]
+		loc, err = time.LoadLocation(name)
+		if err != nil {
+			err = fmt.Errorf("invalid expr %v: %v", expr, err)
+			return
+		}
+		spec = strings.TrimSpace(spec[i:])
+	}
+
+	if strings.HasPrefix(spec, "@every ") {
+		var d time.Duration
+		d, err = time.ParseDuration(strings.TrimSpace(spec[len("@every "):]))
+		if err != nil {
+			err = fmt.Errorf("invalid expr %v: %v", expr, err)
+			return
+		}
+		if d < time.Second {
+			d = time.Second
+		}
+		cronExpr = &CronExpr{every: d - d%time.Second, loc: loc, expr: expr}
+		return
+	}
+	if strings.HasPrefix(spec, "@") {
+		s, ok := descriptors[strings.ToLower(spec)]
+		if !ok {
+			err = fmt.Errorf("invalid expr %v: unknown descriptor", expr)
+			return
+		}
+		spec = s
+	}
+
+	fields := strings.Fields(spec)
 	if len(fields) != 5 && len(fields) != 6 {
 		err = fmt.Errorf("invalid expr %v: expected 5 or 6 fields, got %v", expr, len(fields))
 		return
@@ -39,33 +140,35 @@ func NewCronExpr(expr string) (cronExpr *CronExpr, err error) {
 	}
 
 	cronExpr = new(CronExpr)
+	cronExpr.loc = loc
+	cronExpr.expr = expr
 	// Seconds
-	cronExpr.sec, err = parseCronField(fields[0], 0, 59)
+	cronExpr.sec, err = parseCronField(fields[0], 0, 59, nil)
 	if err != nil {
 		goto onError
 	}
 	// Minutes
-	cronExpr.min, err = parseCronField(fields[1], 0, 59)
+	cronExpr.min, err = parseCronField(fields[1], 0, 59, nil)
 	if err != nil {
 		goto onError
 	}
 	// Hours
-	cronExpr.hour, err = parseCronField(fields[2], 0, 23)
+	cronExpr.hour, err = parseCronField(fields[2], 0, 23, nil)
 	if err != nil {
 		goto onError
 	}
 	// Day of month
-	cronExpr.dom, err = parseCronField(fields[3], 1, 31)
+	err = cronExpr.parseDom(fields[3])
 	if err != nil {
 		goto onError
 	}
 	// Month
-	cronExpr.month, err = parseCronField(fields[4], 1, 12)
+	cronExpr.month, err = parseCronField(fields[4], 1, 12, monthNames)
 	if err != nil {
 		goto onError
 	}
 	// Day of week
-	cronExpr.dow, err = parseCronField(fields[5], 0, 6)
+	err = cronExpr.parseDow(fields[5])
 	if err != nil {
 		goto onError
 	}
@@ -76,13 +179,115 @@ onError:
 	return
 }
 
+func (e *CronExpr) String() string {
+	return e.expr
+}
+
+// Location returns the location the times are matched in, nil for the
+// location of the time given to Next
+func (e *CronExpr) Location() *time.Location {
+	return e.loc
+}
+
+func (e *CronExpr) parseDom(field string) error {
+	if field == "?" {
+		e.dom = allDom
+		return nil
+	}
+
+	var rest []string
+	for _, f := range strings.Split(field, ",") {
+		switch {
+		case f == "L":
+			e.lastDom = true
+		case f == "LW":
+			e.lastWeekDom = true
+		case strings.HasSuffix(f, "W"):
+			day, err := strconv.Atoi(f[:len(f)-1])
+			if err != nil || day < 1 || day > 31 {
+				return fmt.Errorf("invalid nearest weekday: %v", f)
+			}
+			e.nearestDom |= 1 << uint(day)
+		default:
+			rest = append(rest, f)
+		}
+	}
+
+	if len(rest) > 0 {
+		var err error
+		e.dom, err = parseCronField(strings.Join(rest, ","), 1, 31, nil)
+		return err
+	}
+	return nil
+}
+
+func (e *CronExpr) parseDow(field string) error {
+	if field == "?" {
+		e.dow = allDow
+		return nil
+	}
+
+	var rest []string
+	for _, f := range strings.Split(field, ",") {
+		switch {
+		case strings.Contains(f, "#"):
+			i := strings.Index(f, "#")
+			dow, err := parseCronValue(f[:i], 0, 7, dowNames)
+			if err != nil {
+				return err
+			}
+			dow %= 7
+			n, err := strconv.Atoi(f[i+1:])
+			if err != nil || n < 1 || n > 5 {
+				return fmt.Errorf("invalid nth weekday: %v", f)
+			}
+			e.nthDow[dow] |= 1 << uint(n)
+		case len(f) > 1 && strings.HasSuffix(f, "L"):
+			dow, err := parseCronValue(f[:len(f)-1], 0, 7, dowNames)
+			if err != nil {
+				return err
+			}
+			e.lastDow |= 1 << uint(dow%7)
+		default:
+			rest = append(rest, f)
+		}
+	}
+
+	if len(rest) > 0 {
+		var err error
+		e.dow, err = parseCronField(strings.Join(rest, ","), 0, 7, dowNames)
+		// 7 is Sunday
+		if e.dow&(1<<7) != 0 {
+			e.dow = e.dow&^(1<<7) | 1
+		}
+		return err
+	}
+	return nil
+}
+
+func parseCronValue(s string, min int, max int, names map[string]int) (int, error) {
+	if v, ok := names[strings.ToUpper(s)]; ok {
+		return v, nil
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value: %v", s)
+	}
+	if v < min || v > max {
+		return 0, fmt.Errorf("out of range [%v, %v]: %v", min, max, s)
+	}
+	return v, nil
+}
+
 // 1. *
 // 2. num
 // 3. num-num
 // 4. */num
 // 5. num/num (means num-max/num)
 // 6. num-num/num
-func parseCronField(field string, min int, max int) (cronField uint64, err error) {
+//
+// num may be one of names
+func parseCronField(field string, min int, max int, names map[string]int) (cronField uint64, err error) {
 	fields := strings.Split(field, ",")
 	for _, field := range fields {
 		rangeAndIncr := strings.Split(field, "/")
@@ -108,7 +313,7 @@ func parseCronField(field string, min int, max int) (cronField uint64, err error
 			end = max
 		} else {
 			// start
-			start, err = strconv.Atoi(startAndEnd[0])
+			start, err = atoi(startAndEnd[0], names)
 			if err != nil {
 				err = fmt.Errorf("invalid range: %v", rangeAndIncr[0])
 				return
@@ -121,7 +326,7 @@ func parseCronField(field string, min int, max int) (cronField uint64, err error
 					end = start
 				}
 			} else {
-				end, err = strconv.Atoi(startAndEnd[1])
+				end, err = atoi(startAndEnd[1], names)
 				if err != nil {
 					err = fmt.Errorf("invalid range: %v", rangeAndIncr[0])
 					return
@@ -171,32 +376,147 @@ func parseCronField(field string, min int, max int) (cronField uint64, err error
 	return
 }
 
+func atoi(s string, names map[string]int) (int, error) {
+	if v, ok := names[strings.ToUpper(s)]; ok {
+		return v, nil
+	}
+	return strconv.Atoi(s)
+}
+
+func daysIn(t time.Time) int {
+	return time.Date(t.Year(), t.Month()+1, 0, 0, 0, 0, 0, time.UTC).Day()
+}
+
+// the day of the month of the weekday nearest to day, in the same month
+func nearestWeekday(t time.Time, day int) int {
+	last := daysIn(t)
+	if day > last {
+		return 0
+	}
+
+	switch time.Date(t.Year(), t.Month(), day, 0, 0, 0, 0, time.UTC).Weekday() {
+	case time.Saturday:
+		if day == 1 {
+			return day + 2
+		}
+		return day - 1
+	case time.Sunday:
+		if day == last {
+			return day - 2
+		}
+		return day + 1
+	}
+	return day
+}
+
+func (e *CronExpr) matchDom(t time.Time) bool {
+	day := t.Day()
+	if 1<<uint(day)&e.dom != 0 {
+		return true
+	}
+
+	last := daysIn(t)
+	if e.lastDom && day == last {
+		return true
+	}
+	if e.lastWeekDom && day == nearestWeekday(t, last) {
+		return true
+	}
+	for n := 1; e.nearestDom>>uint(n) != 0; n++ {
+		if 1<<uint(n)&e.nearestDom != 0 && day == nearestWeekday(t, n) {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *CronExpr) matchDow(t time.Time) bool {
+	dow := t.Weekday()
+	if 1<<uint(dow)&e.dow != 0 {
+		return true
+	}
+
+	if 1<<uint(dow)&e.lastDow != 0 && t.Day()+7 > daysIn(t) {
+		return true
+	}
+	return 1<<uint((t.Day()-1)/7+1)&e.nthDow[dow] != 0
+}
+
+func (e *CronExpr) domBlank() bool {
+	return e.dom == allDom && !e.lastDom && !e.lastWeekDom && e.nearestDom == 0
+}
+
+func (e *CronExpr) dowBlank() bool {
+	return e.dow == allDow && e.lastDow == 0 && e.nthDow == [7]uint8{}
+}
+
 func (e *CronExpr) matchDay(t time.Time) bool {
 	// day-of-month blank
-	if e.dom == 0xfffffffe {
-		return 1<<uint(t.Weekday())&e.dow != 0
+	if e.domBlank() {
+		return e.matchDow(t)
 	}
 
 	// day-of-week blank
-	if e.dow == 0x7f {
-		return 1<<uint(t.Day())&e.dom != 0
+	if e.dowBlank() {
+		return e.matchDom(t)
+	}
+
+	return e.matchDow(t) || e.matchDom(t)
+}
+
+func (e *CronExpr) matchHour(t time.Time) bool {
+	if 1<<uint(t.Hour())&e.hour != 0 {
+		return true
+	}
+
+	// the hours skipped by DST run in the hour after the gap
+	start := time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, t.Location())
+	prev := start.Add(-time.Hour)
+	if prev.Day() != t.Day() {
+		return false
+	}
+	for h := prev.Hour() + 1; h < t.Hour(); h++ {
+		if 1<<uint(h)&e.hour != 0 {
+			return true
+		}
 	}
+	return false
+}
 
-	return 1<<uint(t.Weekday())&e.dow != 0 ||
-		1<<uint(t.Day())&e.dom != 0
+// reports whether the wall clock of t was shown before by DST
+func repeated(t time.Time) bool {
+	_, offset := t.Zone()
+	for _, d := range []time.Duration{30 * time.Minute, time.Hour, 2 * time.Hour} {
+		if _, prev := t.Add(-d).Zone(); time.Duration(prev-offset)*time.Second == d {
+			return true
+		}
+	}
+	return false
 }
 
 // goroutine safe
+//
+// Next returns the first time after t matching the expression, in the
+// location of the expression if any, or the zero time if none in 5 years
 func (e *CronExpr) Next(t time.Time) time.Time {
+	if e.every > 0 {
+		return t.Add(e.every - time.Duration(t.Nanosecond())*time.Nanosecond)
+	}
+
+	loc := e.loc
+	if loc == nil {
+		loc = t.Location()
+	}
+
 	// the upcoming second
-	t = t.Truncate(time.Second).Add(time.Second)
+	t = t.In(loc).Truncate(time.Second).Add(time.Second)
 
 	year := t.Year()
 	initFlag := false
 
 retry:
 	// Year
-	if t.Year() > year+1 {
+	if t.Year() > year+5 {
 		return time.Time{}
 	}
 
@@ -204,7 +524,7 @@ retry:
 	for 1<<uint(t.Month())&e.month == 0 {
 		if !initFlag {
 			initFlag = true
-			t = time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, t.Location())
+			t = time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, loc)
 		}
 
 		t = t.AddDate(0, 1, 0)
@@ -217,24 +537,26 @@ retry:
 	for !e.matchDay(t) {
 		if !initFlag {
 			initFlag = true
-			t = time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+			t = time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
 		}
 
-		t = t.AddDate(0, 0, 1)
-		if t.Day() == 1 {
+		month := t.Month()
+		t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+		if t.Month() != month {
 			goto retry
 		}
 	}
 
 	// Hours
-	for 1<<uint(t.Hour())&e.hour == 0 {
+	for !e.matchHour(t) {
 		if !initFlag {
 			initFlag = true
-			t = t.Truncate(time.Hour)
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, loc)
 		}
 
+		day := t.Day()
 		t = t.Add(time.Hour)
-		if t.Hour() == 0 {
+		if t.Day() != day {
 			goto retry
 		}
 	}
@@ -246,8 +568,9 @@ retry:
 			t = t.Truncate(time.Minute)
 		}
 
+		hour := t.Hour()
 		t = t.Add(time.Minute)
-		if t.Minute() == 0 {
+		if t.Hour() != hour {
 			goto retry
 		}
 	}
@@ -258,11 +581,18 @@ retry:
 			initFlag = true
 		}
 
+		minute := t.Minute()
 		t = t.Add(time.Second)
-		if t.Second() == 0 {
+		if t.Minute() != minute {
 			goto retry
 		}
 	}
 
+	// fixed hours run once when DST repeats them
+	if e.hour != allHours && repeated(t) {
+		t = t.Add(time.Second)
+		goto retry
+	}
+
 	return t
 }
@@ -3,7 +3,11 @@ package timer_test
 import (
 	"fmt"
 	"gitee.com/aarlin/leaflet/timer"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"time"
+	_ "time/tzdata"
 )
 
 func ExampleTimer() {
@@ -65,3 +69,241 @@ func ExampleCron() {
 	// Output:
 	// My name is Leaf
 }
+
+func ExampleTicker() {
+	// the fake clock makes the frames exact
+	clock := timer.NewFakeClock(time.Unix(0, 0))
+	d := timer.NewDispatcherWithClock(10, clock)
+
+	var t *timer.Ticker
+	t = d.TickFunc(20*time.Millisecond, func(frame uint64, dt time.Duration) {
+		fmt.Println(frame, dt)
+		switch frame {
+		case 1:
+			// overrun, frame 2 is missed
+			clock.Advance(50 * time.Millisecond)
+		case 4:
+			t.Stop()
+		}
+	})
+	t.Policy = timer.TickSkip
+
+	// dispatch
+	clock.Advance(100 * time.Millisecond)
+
+	stats := t.Stats()
+	fmt.Println(stats.Frames, stats.Skipped, stats.Overruns)
+
+	// Output:
+	// 1 20ms
+	// 3 40ms
+	// 4 20ms
+	// 3 1 1
+}
+
+func ExampleNewWheelDispatcher() {
+	d := timer.NewWheelDispatcher(10, 10*time.Millisecond)
+	defer d.Close()
+
+	start := time.Now()
+	d.AfterFunc(30*time.Millisecond, func() {
+		fmt.Println("fired, not early:", time.Since(start) >= 30*time.Millisecond)
+	})
+	d.AfterFunc(20*time.Millisecond, func() {
+		fmt.Println("will not print")
+	}).Stop()
+
+	// dispatch
+	(<-d.ChanTimer).Cb()
+
+	// Output:
+	// fired, not early: true
+}
+
+func ExampleNewCronExprIn() {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		return
+	}
+
+	next := func(expr string, t time.Time) {
+		cronExpr, err := timer.NewCronExprIn(expr, newYork)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		fmt.Println(cronExpr.Next(t).Format("Mon 2006-01-02 15:04:05 MST"))
+	}
+
+	t := time.Date(2024, 3, 1, 12, 0, 0, 0, newYork)
+	next("0 0 4 * * MON-FRI", t)
+	next("@daily", t)
+	next("@every 90m", t)
+	next("0 0 12 L * ?", t)
+	next("0 0 12 LW FEB *", t)
+	next("0 0 12 ? * SUN#2", t)
+	next("0 0 12 ? * 7#2", t)
+	next("0 0 12 ? * 5L", t)
+	next("0 0 12 16W * ?", t)
+	next("TZ=Asia/Shanghai 0 0 9 * * *", t)
+
+	// 2:30 does not exist on 2024-03-10, 1:30 happens twice on 2024-11-03
+	next("0 30 2 * * *", time.Date(2024, 3, 10, 0, 0, 0, 0, newYork))
+	next("0 30 1 * * *", time.Date(2024, 11, 3, 1, 30, 0, 0, newYork))
+
+	next("0 0 12 ? * FOO", t)
+
+	// Output:
+	// Mon 2024-03-04 04:00:00 EST
+	// Sat 2024-03-02 00:00:00 EST
+	// Fri 2024-03-01 13:30:00 EST
+	// Sun 2024-03-31 12:00:00 EDT
+	// Fri 2025-02-28 12:00:00 EST
+	// Sun 2024-03-10 12:00:00 EDT
+	// Sun 2024-03-10 12:00:00 EDT
+	// Fri 2024-03-29 12:00:00 EDT
+	// Fri 2024-03-15 12:00:00 EDT
+	// Sat 2024-03-02 09:00:00 CST
+	// Sun 2024-03-10 03:30:00 EDT
+	// Mon 2024-11-04 01:30:00 EST
+	// invalid expr 0 0 12 ? * FOO: invalid range: FOO
+}
+
+func ExampleScheduler() {
+	dir, err := ioutil.TempDir("", "leaf")
+	if err != nil {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	// the server was down for 3 days
+	store := timer.NewFileStore(filepath.Join(dir, "jobs.json"))
+	down := time.Now().Add(-72*time.Hour - time.Minute)
+	store.Save("reset", down)
+	store.Save("reward", down)
+	store.Save("report", down)
+
+	d := timer.NewDispatcher(10)
+	sched, err := timer.NewScheduler(d, store)
+	if err != nil {
+		return
+	}
+	defer sched.Stop()
+
+	// jobs
+	runs := make(map[string]int)
+	daily, _ := timer.NewCronExpr("@every 24h")
+	add := func(name string, policy timer.MissedPolicy) {
+		sched.AddJob(name, daily, policy, func(at time.Time) {
+			runs[name]++
+		})
+	}
+	add("reset", timer.MissedRunOnce)
+	add("reward", timer.MissedRunAll)
+	add("report", timer.MissedSkip)
+
+	// dispatch the missed runs of reset and reward
+	(<-d.ChanTimer).Cb()
+	(<-d.ChanTimer).Cb()
+	fmt.Println(runs["reset"], runs["reward"], runs["report"])
+
+	// by hand
+	sched.RunJob("report")
+	fmt.Println(runs["report"])
+
+	// the last runs are recorded
+	lasts, _ := timer.NewFileStore(filepath.Join(dir, "jobs.json")).Load()
+	for _, job := range sched.Jobs() {
+		fmt.Println(job.Name, job.Policy, lasts[job.Name].After(down), job.Next.After(time.Now()))
+	}
+
+	// Output:
+	// 1 3 0
+	// 1
+	// report skip false true
+	// reset once true true
+	// reward all true true
+}
+
+func ExampleDispatcher_Group() {
+	d := timer.NewDispatcher(10)
+
+	// the timers of a player
+	player := d.Group("player:42")
+	save := player.AfterFunc(time.Hour, func() {
+		fmt.Println("save")
+	})
+	player.AfterFunc(time.Hour, func() {
+		fmt.Println("will not print")
+	})
+	cronExpr, err := timer.NewCronExpr("@yearly")
+	if err != nil {
+		return
+	}
+	reward := player.CronFunc(cronExpr, func() {
+		fmt.Println("will not print")
+	})
+	d.AfterFunc(20*time.Millisecond, func() {
+		fmt.Println("world")
+	})
+
+	// pause, resume and reschedule
+	save.Pause()
+	fmt.Println(save.Paused(), save.Remaining() > 59*time.Minute)
+	save.Resume()
+	save.Reset(10 * time.Millisecond)
+	fmt.Println(len(d.Timers()), player.Len())
+
+	// dispatch
+	(<-d.ChanTimer).Cb()
+
+	// a paused cron is still in the group
+	reward.Pause()
+	fmt.Println(player.Len())
+
+	// logout, the paused cron does not come back
+	player.Stop()
+	reward.Resume()
+	fmt.Println(len(d.Timers()), player.Len())
+	(<-d.ChanTimer).Cb()
+
+	// Output:
+	// true true
+	// 4 3
+	// save
+	// 2
+	// 1 0
+	// world
+}
+
+func ExampleFakeClock() {
+	clock := timer.NewFakeClock(time.Date(2024, 1, 1, 23, 0, 0, 0, time.UTC))
+	d := timer.NewDispatcherWithClock(10, clock)
+
+	// daily reset
+	cronExpr, err := timer.NewCronExprIn("@daily", time.UTC)
+	if err != nil {
+		return
+	}
+	d.CronFunc(cronExpr, func() {
+		fmt.Println("reset at", d.Clock().Now().Format("01-02 15:04"))
+	})
+
+	// buff expiry
+	buff := d.AfterFunc(90*time.Minute, func() {
+		fmt.Println("buff expired at", d.Clock().Now().Format("01-02 15:04"))
+	})
+	fmt.Println(buff.Remaining())
+
+	// the due timers fire in order, no sleep
+	clock.Advance(49 * time.Hour)
+	fmt.Println(buff.Active())
+
+	// Output:
+	// 1h30m0s
+	// reset at 01-02 00:00
+	// buff expired at 01-02 00:30
+	// reset at 01-03 00:00
+	// reset at 01-04 00:00
+	// false
+}
@@ -0,0 +1,110 @@
+package timer
+
+import (
+	"reflect"
+	"runtime"
+	"sort"
+	"time"
+)
+
+// Group names a set of timers, crons and tickers stopped together,
+// such as those of a player
+type Group struct {
+	disp *Dispatcher
+	name string
+}
+
+// Group returns the group of the name, empty groups cost nothing
+func (disp *Dispatcher) Group(name string) *Group {
+	return &Group{disp: disp, name: name}
+}
+
+func (g *Group) Name() string {
+	return g.name
+}
+
+func (g *Group) AfterFunc(d time.Duration, cb func()) *Timer {
+	return g.disp.afterFunc(g.name, d, cb, cb)
+}
+
+func (g *Group) CronFunc(cronExpr *CronExpr, cb func()) *Cron {
+	return g.disp.cronFunc(g.name, cronExpr, cb)
+}
+
+func (g *Group) TickFunc(interval time.Duration, cb func(frame uint64, dt time.Duration)) *Ticker {
+	return g.disp.tickFunc(g.name, interval, cb)
+}
+
+// Len returns the number of the pending and paused timers of the group
+func (g *Group) Len() int {
+	return len(g.disp.groups[g.name])
+}
+
+// Stop stops all the timers, crons and tickers of the group
+func (g *Group) Stop() {
+	for t := range g.disp.groups[g.name] {
+		t.Stop()
+	}
+}
+
+func (disp *Dispatcher) track(t *Timer) {
+	disp.timers[t] = struct{}{}
+	if t.group == "" {
+		return
+	}
+	timers := disp.groups[t.group]
+	if timers == nil {
+		timers = make(map[*Timer]struct{})
+		disp.groups[t.group] = timers
+	}
+	timers[t] = struct{}{}
+}
+
+func (disp *Dispatcher) untrack(t *Timer) {
+	delete(disp.timers, t)
+	if t.group == "" {
+		return
+	}
+	timers := disp.groups[t.group]
+	delete(timers, t)
+	if len(timers) == 0 {
+		delete(disp.groups, t.group)
+	}
+}
+
+type TimerInfo struct {
+	Group string
+	// the name of the callback
+	Func      string
+	Remaining time.Duration
+	Paused    bool
+}
+
+// Timers returns the pending and paused timers, the crons and tickers
+// included, the nearest first
+func (disp *Dispatcher) Timers() []TimerInfo {
+	infos := make([]TimerInfo, 0, len(disp.timers))
+	for t := range disp.timers {
+		infos = append(infos, TimerInfo{
+			Group:     t.group,
+			Func:      funcName(t.fn),
+			Remaining: t.Remaining(),
+			Paused:    t.paused,
+		})
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Remaining < infos[j].Remaining
+	})
+	return infos
+}
+
+func funcName(fn interface{}) string {
+	v := reflect.ValueOf(fn)
+	if v.Kind() != reflect.Func || v.IsNil() {
+		return "?"
+	}
+	if f := runtime.FuncForPC(v.Pointer()); f != nil {
+		return f.Name()
+	}
+	return "?"
+}
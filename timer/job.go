@@ -0,0 +1,232 @@
+package timer
+
+import (
+	"fmt"
+	"gitee.com/aarlin/leaflet/log"
+	"sort"
+	"time"
+)
+
+// what a job does with the runs missed while the server was down
+type MissedPolicy int
+
+const (
+	// runs once, at the latest missed time
+	MissedRunOnce MissedPolicy = iota
+	// runs every missed time in order, up to Scheduler.MaxMissed
+	MissedRunAll
+	// drops the missed runs
+	MissedSkip
+)
+
+func (p MissedPolicy) String() string {
+	switch p {
+	case MissedRunOnce:
+		return "once"
+	case MissedRunAll:
+		return "all"
+	case MissedSkip:
+		return "skip"
+	default:
+		return fmt.Sprintf("MissedPolicy(%d)", int(p))
+	}
+}
+
+// JobStore keeps the last run times of the jobs across restarts
+type JobStore interface {
+	// returns the last run times by job name
+	Load() (map[string]time.Time, error)
+	Save(name string, last time.Time) error
+}
+
+type JobInfo struct {
+	Name   string
+	Expr   string
+	Policy MissedPolicy
+	// the time of the latest run, or when the job was first added
+	Last time.Time
+	Next time.Time
+}
+
+type job struct {
+	name   string
+	expr   *CronExpr
+	policy MissedPolicy
+	cb     func(at time.Time)
+	last   time.Time
+	next   time.Time
+	t      *Timer
+}
+
+// Scheduler runs cron jobs and records their last run times in a
+// store, the runs missed while the server was down are detected when
+// the jobs are added and handled by the policy of the job
+//
+// goroutine not safe, use it on the goroutine of the dispatcher
+type Scheduler struct {
+	// the most runs of a MissedRunAll job handled on startup,
+	// the older ones are dropped
+	MaxMissed int
+
+	disp  *Dispatcher
+	store JobStore
+	lasts map[string]time.Time
+	jobs  map[string]*job
+}
+
+const defaultMaxMissed = 1000
+
+// NewScheduler loads the last run times from store
+func NewScheduler(disp *Dispatcher, store JobStore) (*Scheduler, error) {
+	lasts, err := store.Load()
+	if err != nil {
+		return nil, err
+	}
+	if lasts == nil {
+		lasts = make(map[string]time.Time)
+	}
+
+	s := new(Scheduler)
+	s.MaxMissed = defaultMaxMissed
+	s.disp = disp
+	s.store = store
+	s.lasts = lasts
+	s.jobs = make(map[string]*job)
+	return s, nil
+}
+
+// AddJob calls cb with the scheduled time at every time matching
+// expr. The runs missed since the last recorded run are handled by
+// policy on the next dispatch, a new job has none
+func (s *Scheduler) AddJob(name string, expr *CronExpr, policy MissedPolicy, cb func(at time.Time)) error {
+	if _, ok := s.jobs[name]; ok {
+		return fmt.Errorf("job %v: already added", name)
+	}
+
+	now := s.disp.clock.Now()
+	j := &job{name: name, expr: expr, policy: policy, cb: cb}
+	if last, ok := s.lasts[name]; ok {
+		j.last = last
+	} else {
+		// missed runs are counted from now on
+		j.last = now
+		s.save(j)
+	}
+	s.jobs[name] = j
+
+	if missed := s.missed(j, now); len(missed) > 0 {
+		s.disp.AfterFunc(0, func() {
+			for _, at := range missed {
+				if s.jobs[name] != j {
+					return
+				}
+				s.run(j, at)
+			}
+		})
+	}
+	s.schedule(j)
+	return nil
+}
+
+// the missed runs of the job to run by its policy
+func (s *Scheduler) missed(j *job, now time.Time) []time.Time {
+	var missed []time.Time
+	dropped := 0
+	for t := j.expr.Next(j.last); !t.IsZero() && !t.After(now); t = j.expr.Next(t) {
+		switch {
+		case j.policy == MissedRunAll && len(missed) < s.MaxMissed:
+			missed = append(missed, t)
+		case j.policy == MissedRunAll && s.MaxMissed > 0:
+			missed = append(missed[1:], t)
+			dropped++
+		case j.policy == MissedRunOnce:
+			missed = append(missed[:0], t)
+		default:
+			dropped++
+		}
+	}
+	if j.policy == MissedRunOnce && len(missed) > 0 {
+		log.ReleaseF("job %v: missed since %v, run once", j.name, j.last)
+	} else if len(missed) > 0 || dropped > 0 {
+		log.ReleaseF("job %v: missed since %v, run %v, drop %v", j.name, j.last, len(missed), dropped)
+	}
+	return missed
+}
+
+func (s *Scheduler) schedule(j *job) {
+	now := s.disp.clock.Now()
+	j.next = j.expr.Next(now)
+	if j.next.IsZero() {
+		return
+	}
+	j.t = s.disp.AfterFunc(j.next.Sub(now), func() {
+		at := j.next
+		defer s.schedule(j)
+		s.run(j, at)
+	})
+}
+
+// calls the job and records the run, even if the job panics
+func (s *Scheduler) run(j *job, at time.Time) {
+	defer func() {
+		if at.After(j.last) {
+			j.last = at
+			s.save(j)
+		}
+	}()
+	j.cb(at)
+}
+
+func (s *Scheduler) save(j *job) {
+	s.lasts[j.name] = j.last
+	if err := s.store.Save(j.name, j.last); err != nil {
+		log.ErrorF("job %v: save last run: %v", j.name, err)
+	}
+}
+
+// RemoveJob stops the job, its last run time is kept in the store
+func (s *Scheduler) RemoveJob(name string) {
+	j, ok := s.jobs[name]
+	if !ok {
+		return
+	}
+	if j.t != nil {
+		j.t.Stop()
+	}
+	delete(s.jobs, name)
+}
+
+// RunJob calls the job now, out of its schedule, the run is not recorded
+func (s *Scheduler) RunJob(name string) error {
+	j, ok := s.jobs[name]
+	if !ok {
+		return fmt.Errorf("job %v: not found", name)
+	}
+	j.cb(s.disp.clock.Now())
+	return nil
+}
+
+// Jobs returns the jobs sorted by name
+func (s *Scheduler) Jobs() []JobInfo {
+	infos := make([]JobInfo, 0, len(s.jobs))
+	for _, j := range s.jobs {
+		infos = append(infos, JobInfo{
+			Name:   j.name,
+			Expr:   j.expr.String(),
+			Policy: j.policy,
+			Last:   j.last,
+			Next:   j.next,
+		})
+	}
+	sort.Slice(infos, func(i, k int) bool {
+		return infos[i].Name < infos[k].Name
+	})
+	return infos
+}
+
+// Stop stops all the jobs
+func (s *Scheduler) Stop() {
+	for name := range s.jobs {
+		s.RemoveJob(name)
+	}
+}
@@ -0,0 +1,96 @@
+package timer
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// FileStore keeps the last run times of the jobs in a JSON file,
+// rewritten on every save
+//
+// goroutine safe
+type FileStore struct {
+	path  string
+	mutex sync.Mutex
+	lasts map[string]time.Time
+}
+
+func NewFileStore(path string) *FileStore {
+	return &FileStore{path: path}
+}
+
+// the caller must hold the lock
+func (fs *FileStore) load() error {
+	if fs.lasts != nil {
+		return nil
+	}
+
+	data, err := ioutil.ReadFile(fs.path)
+	if os.IsNotExist(err) {
+		fs.lasts = make(map[string]time.Time)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	lasts := make(map[string]time.Time)
+	if err := json.Unmarshal(data, &lasts); err != nil {
+		return err
+	}
+	fs.lasts = lasts
+	return nil
+}
+
+func (fs *FileStore) Load() (map[string]time.Time, error) {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+
+	if err := fs.load(); err != nil {
+		return nil, err
+	}
+	lasts := make(map[string]time.Time, len(fs.lasts))
+	for name, last := range fs.lasts {
+		lasts[name] = last
+	}
+	return lasts, nil
+}
+
+func (fs *FileStore) Save(name string, last time.Time) error {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+
+	if err := fs.load(); err != nil {
+		return err
+	}
+	fs.lasts[name] = last
+
+	data, err := json.MarshalIndent(fs.lasts, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	// replaces the file at once, a crash leaves the old one
+	tmp, err := ioutil.TempFile(filepath.Dir(fs.path), filepath.Base(fs.path)+".*")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), fs.path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
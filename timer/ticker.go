@@ -0,0 +1,187 @@
+package timer
+
+import "time"
+
+// what a ticker does with the frames it missed
+type TickPolicy int
+
+const (
+	// runs the missed frames back to back, each with the interval as dt
+	TickCatchUp TickPolicy = iota
+	// drops the missed frames, the next frame gets their time in dt
+	TickSkip
+)
+
+type TickStats struct {
+	// callbacks run
+	Frames uint64
+	// frames dropped by TickSkip or MaxCatchUp
+	Skipped uint64
+	// callbacks which took longer than the interval
+	Overruns uint64
+	// delay of the frames after their scheduled time
+	LastDrift time.Duration
+	MaxDrift  time.Duration
+	AvgDrift  time.Duration
+	// time spent by the callbacks
+	LastCost time.Duration
+	MaxCost  time.Duration
+}
+
+// Ticker runs a callback at a fixed rate, frames are scheduled from
+// the start time so that the delays do not add up
+//
+// goroutine not safe, use it on the goroutine of the dispatcher
+type Ticker struct {
+	Policy TickPolicy
+	// the most missed frames run back to back by TickCatchUp,
+	// the others are dropped, 0 means no limit
+	MaxCatchUp int
+
+	disp       *Dispatcher
+	group      string
+	interval   time.Duration
+	cb         func(frame uint64, dt time.Duration)
+	t          *Timer
+	frame      uint64
+	next       time.Time
+	paused     bool
+	stopped    bool
+	ticking    bool
+	stats      TickStats
+	totalDrift time.Duration
+}
+
+// TickFunc calls cb every interval with the frame number, starting
+// at 1, and the simulated time since the previous frame
+func (disp *Dispatcher) TickFunc(interval time.Duration, cb func(frame uint64, dt time.Duration)) *Ticker {
+	return disp.tickFunc("", interval, cb)
+}
+
+func (disp *Dispatcher) tickFunc(group string, interval time.Duration, cb func(frame uint64, dt time.Duration)) *Ticker {
+	if interval <= 0 {
+		panic("invalid tick interval")
+	}
+
+	t := new(Ticker)
+	t.disp = disp
+	t.group = group
+	t.interval = interval
+	t.cb = cb
+	t.next = disp.clock.Now().Add(interval)
+	t.schedule()
+	return t
+}
+
+func (t *Ticker) schedule() {
+	t.t = t.disp.afterFunc(t.group, t.next.Sub(t.disp.clock.Now()), t.tick, t.cb)
+}
+
+func (t *Ticker) tick() {
+	if t.paused || t.stopped {
+		return
+	}
+
+	now := t.disp.clock.Now()
+	drift := now.Sub(t.next)
+	if drift < 0 {
+		drift = 0
+	}
+	missed := uint64(drift / t.interval)
+
+	// frames to advance
+	advance := uint64(1)
+	switch t.Policy {
+	case TickSkip:
+		advance += missed
+		t.stats.Skipped += missed
+	default:
+		if t.MaxCatchUp > 0 && missed > uint64(t.MaxCatchUp) {
+			skipped := missed - uint64(t.MaxCatchUp)
+			advance += skipped
+			t.stats.Skipped += skipped
+		}
+	}
+	t.frame += advance
+	t.next = t.next.Add(time.Duration(advance) * t.interval)
+
+	t.stats.Frames++
+	t.stats.LastDrift = drift
+	if drift > t.stats.MaxDrift {
+		t.stats.MaxDrift = drift
+	}
+	t.totalDrift += drift
+	t.stats.AvgDrift = t.totalDrift / time.Duration(t.stats.Frames)
+
+	start := t.disp.clock.Now()
+	t.ticking = true
+	defer func() {
+		t.ticking = false
+	}()
+	t.cb(t.frame, time.Duration(advance)*t.interval)
+	cost := t.disp.clock.Now().Sub(start)
+	t.stats.LastCost = cost
+	if cost > t.stats.MaxCost {
+		t.stats.MaxCost = cost
+	}
+	if cost > t.interval {
+		t.stats.Overruns++
+	}
+
+	// the callback may stop or pause the ticker
+	if !t.paused && !t.stopped {
+		t.schedule()
+	}
+}
+
+func (t *Ticker) Frame() uint64 {
+	return t.frame
+}
+
+func (t *Ticker) Stats() TickStats {
+	return t.stats
+}
+
+// Pause stops the frames until Resume, the paused time is not
+// given to the callback. The ticker stays in its group
+func (t *Ticker) Pause() {
+	if t.paused || t.stopped {
+		return
+	}
+	if !t.t.Active() {
+		// the group was stopped
+		if !t.ticking {
+			t.stopped = true
+			return
+		}
+		// paused by the callback, the frame has fired
+		t.schedule()
+	}
+	t.paused = true
+	t.t.Pause()
+}
+
+// Resume runs the next frame one interval from now
+func (t *Ticker) Resume() {
+	if !t.paused || t.stopped {
+		return
+	}
+	// the group was stopped meanwhile
+	if !t.t.Active() {
+		t.stopped = true
+		return
+	}
+	t.paused = false
+	t.t.Stop()
+	t.next = t.disp.clock.Now().Add(t.interval)
+	t.schedule()
+}
+
+func (t *Ticker) Paused() bool {
+	return t.paused
+}
+
+func (t *Ticker) Stop() {
+	t.stopped = true
+	t.t.Stop()
+}
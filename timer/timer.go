@@ -10,28 +10,92 @@ import (
 // one dispatcher per goroutine (goroutine not safe)
 type Dispatcher struct {
 	ChanTimer chan *Timer
+	clock     Clock
+	// fires the timers on the goroutine advancing the fake clock
+	fake  bool
+	wheel *wheel
+	// the pending and paused timers, by group
+	timers map[*Timer]struct{}
+	groups map[string]map[*Timer]struct{}
 }
 
 func NewDispatcher(l int) *Dispatcher {
+	return NewDispatcherWithClock(l, SystemClock)
+}
+
+// NewDispatcherWithClock returns a dispatcher whose timers follow
+// the clock, see FakeClock for the tests
+func NewDispatcherWithClock(l int, clock Clock) *Dispatcher {
 	disp := new(Dispatcher)
 	disp.ChanTimer = make(chan *Timer, l)
+	disp.clock = clock
+	_, disp.fake = clock.(*FakeClock)
+	disp.timers = make(map[*Timer]struct{})
+	disp.groups = make(map[string]map[*Timer]struct{})
 	return disp
 }
 
 // Timer
 type Timer struct {
-	t  *time.Timer
+	t  ClockTimer
 	cb func()
+
+	disp *Dispatcher
+	// the callback of the user, names the timer
+	fn     interface{}
+	group  string
+	when   time.Time
+	paused bool
+	// the remaining time of a paused timer
+	left time.Duration
+
+	// timing wheel
+	wheel      *wheel
+	expires    uint64
+	prev, next *Timer
+}
+
+func (t *Timer) arm(d time.Duration) {
+	if d < 0 {
+		d = 0
+	}
+	// timers never fire early, a fire before when is stale
+	disp := t.disp
+	t.when = disp.clock.Now().Add(d)
+	if disp.wheel != nil {
+		disp.wheel.afterFunc(t, d)
+		return
+	}
+	if disp.fake {
+		t.t = disp.clock.AfterFunc(d, t.Cb)
+		return
+	}
+	t.t = disp.clock.AfterFunc(d, func() {
+		disp.ChanTimer <- t
+	})
+}
+
+func (t *Timer) disarm() {
+	if t.wheel != nil {
+		t.wheel.remove(t)
+	} else if t.t != nil {
+		t.t.Stop()
+	}
 }
 
 func (t *Timer) Stop() {
-	t.t.Stop()
+	t.disarm()
+	t.disp.untrack(t)
 	t.cb = nil
 }
 
 func (t *Timer) Cb() {
+	// stopped, or a stale fire from before Reset or Pause
+	if t.cb == nil || t.paused || t.disp.clock.Now().Before(t.when) {
+		return
+	}
+
 	defer func() {
-		t.cb = nil
 		if r := recover(); r != nil {
 			if conf.LenStackBuf > 0 {
 				buf := make([]byte, conf.LenStackBuf)
@@ -43,53 +107,183 @@ func (t *Timer) Cb() {
 		}
 	}()
 
-	if t.cb != nil {
-		t.cb()
+	cb := t.cb
+	t.cb = nil
+	t.disp.untrack(t)
+	cb()
+}
+
+// Active reports whether the timer is pending or paused
+func (t *Timer) Active() bool {
+	return t.cb != nil
+}
+
+// Remaining returns the time left before the timer fires,
+// 0 once fired or stopped
+func (t *Timer) Remaining() time.Duration {
+	if t.cb == nil {
+		return 0
+	}
+	if t.paused {
+		return t.left
+	}
+	if d := t.when.Sub(t.disp.clock.Now()); d > 0 {
+		return d
+	}
+	return 0
+}
+
+// Reset makes the timer fire d from now, or d after Resume if paused.
+// It returns false if the timer has fired or been stopped
+func (t *Timer) Reset(d time.Duration) bool {
+	if t.cb == nil {
+		return false
+	}
+	if t.paused {
+		t.left = d
+		return true
+	}
+	t.disarm()
+	t.arm(d)
+	return true
+}
+
+// Pause stops the countdown of the timer until Resume
+func (t *Timer) Pause() {
+	if t.cb == nil || t.paused {
+		return
 	}
+	t.left = t.Remaining()
+	t.paused = true
+	t.disarm()
+}
+
+// Resume fires the timer after the time remaining at Pause
+func (t *Timer) Resume() {
+	if t.cb == nil || !t.paused {
+		return
+	}
+	t.paused = false
+	t.arm(t.left)
+}
+
+func (t *Timer) Paused() bool {
+	return t.paused
 }
 
 func (disp *Dispatcher) AfterFunc(d time.Duration, cb func()) *Timer {
+	return disp.afterFunc("", d, cb, cb)
+}
+
+func (disp *Dispatcher) afterFunc(group string, d time.Duration, cb func(), fn interface{}) *Timer {
 	t := new(Timer)
 	t.cb = cb
-	t.t = time.AfterFunc(d, func() {
-		disp.ChanTimer <- t
-	})
+	t.disp = disp
+	t.fn = fn
+	t.group = group
+	disp.track(t)
+	t.arm(d)
 	return t
 }
 
+func (disp *Dispatcher) Clock() Clock {
+	return disp.clock
+}
+
+// Close stops the timing wheel of the dispatcher if any,
+// its pending timers never fire
+func (disp *Dispatcher) Close() {
+	if disp.wheel != nil {
+		disp.wheel.close()
+	}
+}
+
 // Cron
 type Cron struct {
-	t *Timer
+	t       *Timer
+	disp    *Dispatcher
+	group   string
+	expr    *CronExpr
+	cb      func()
+	next    time.Time
+	paused  bool
+	stopped bool
 }
 
 func (c *Cron) Stop() {
+	c.stopped = true
 	if c.t != nil {
 		c.t.Stop()
 	}
 }
 
-func (disp *Dispatcher) CronFunc(cronExpr *CronExpr, _cb func()) *Cron {
-	c := new(Cron)
+// Next returns the next fire time, the zero time if stopped or paused
+func (c *Cron) Next() time.Time {
+	if c.t == nil || !c.t.Active() || c.paused {
+		return time.Time{}
+	}
+	return c.next
+}
 
-	now := time.Now()
-	nextTime := cronExpr.Next(now)
+// Remaining returns the time left before the next fire,
+// 0 if stopped or paused
+func (c *Cron) Remaining() time.Duration {
+	if c.t == nil || c.paused {
+		return 0
+	}
+	return c.t.Remaining()
+}
+
+// Pause stops the cron until Resume, the times matched meanwhile are skipped.
+// The cron stays in its group
+func (c *Cron) Pause() {
+	if c.t == nil || !c.t.Active() || c.paused {
+		return
+	}
+	c.paused = true
+	c.t.Pause()
+}
+
+// Resume fires the cron at the next time matching its expression
+func (c *Cron) Resume() {
+	if !c.paused || c.stopped {
+		return
+	}
+	// the group was stopped meanwhile
+	if !c.t.Active() {
+		c.stopped = true
+		return
+	}
+	c.paused = false
+	c.t.Stop()
+	c.schedule()
+}
+
+func (c *Cron) schedule() {
+	now := c.disp.clock.Now()
+	nextTime := c.expr.Next(now)
 	if nextTime.IsZero() {
-		return c
+		return
 	}
 
 	// callback
-	var cb func()
-	cb = func() {
-		defer _cb()
-
-		now := time.Now()
-		nextTime := cronExpr.Next(now)
-		if nextTime.IsZero() {
-			return
-		}
-		c.t = disp.AfterFunc(nextTime.Sub(now), cb)
-	}
+	c.next = nextTime
+	c.t = c.disp.afterFunc(c.group, nextTime.Sub(now), func() {
+		defer c.cb()
+		c.schedule()
+	}, c.cb)
+}
 
-	c.t = disp.AfterFunc(nextTime.Sub(now), cb)
+func (disp *Dispatcher) CronFunc(cronExpr *CronExpr, cb func()) *Cron {
+	return disp.cronFunc("", cronExpr, cb)
+}
+
+func (disp *Dispatcher) cronFunc(group string, cronExpr *CronExpr, cb func()) *Cron {
+	c := new(Cron)
+	c.disp = disp
+	c.group = group
+	c.expr = cronExpr
+	c.cb = cb
+	c.schedule()
 	return c
 }
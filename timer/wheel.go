@@ -0,0 +1,218 @@
+package timer
+
+import (
+	"sync"
+	"time"
+)
+
+// a hierarchical timing wheel, 256 slots for the nearest ticks and
+// 4 levels of 64 slots above them, up to 2^32 ticks
+const (
+	wheelRootBits  = 8
+	wheelLevelBits = 6
+	wheelRootSize  = 1 << wheelRootBits
+	wheelLevelSize = 1 << wheelLevelBits
+	wheelRootMask  = wheelRootSize - 1
+	wheelLevelMask = wheelLevelSize - 1
+	wheelLevels    = 4
+	wheelMaxTicks  = 1<<(wheelRootBits+wheelLevels*wheelLevelBits) - 1
+)
+
+// the head of a circular list of timers
+type wheelSlot struct {
+	Timer
+}
+
+func (s *wheelSlot) init() {
+	s.prev = &s.Timer
+	s.next = &s.Timer
+}
+
+func (s *wheelSlot) push(t *Timer) {
+	t.prev = s.prev
+	t.next = &s.Timer
+	s.prev.next = t
+	s.prev = t
+}
+
+// moves the timers of the slot to a new list
+func (s *wheelSlot) take() *Timer {
+	if s.next == &s.Timer {
+		return nil
+	}
+
+	first := s.next
+	s.prev.next = nil
+	first.prev = nil
+	s.init()
+	return first
+}
+
+type wheel struct {
+	sync.Mutex
+	disp       *Dispatcher
+	resolution time.Duration
+	start      time.Time
+	// the next tick to run
+	current uint64
+	root    [wheelRootSize]wheelSlot
+	levels  [wheelLevels][wheelLevelSize]wheelSlot
+	// used by run only
+	expired  []*Timer
+	closeSig chan struct{}
+	wg       sync.WaitGroup
+}
+
+// NewWheelDispatcher returns a dispatcher whose timers share a timing
+// wheel driven by one goroutine, their delays are rounded up to the
+// resolution. You must call Close when the dispatcher is no longer used
+func NewWheelDispatcher(l int, resolution time.Duration) *Dispatcher {
+	if resolution <= 0 {
+		panic("invalid wheel resolution")
+	}
+
+	disp := NewDispatcher(l)
+	w := new(wheel)
+	w.disp = disp
+	w.resolution = resolution
+	w.start = time.Now()
+	for i := range w.root {
+		w.root[i].init()
+	}
+	for i := range w.levels {
+		for j := range w.levels[i] {
+			w.levels[i][j].init()
+		}
+	}
+	w.closeSig = make(chan struct{})
+	disp.wheel = w
+
+	w.wg.Add(1)
+	go w.run()
+	return disp
+}
+
+// the caller must hold the lock
+func (w *wheel) add(t *Timer) {
+	delta := t.expires - w.current
+	if int64(delta) < 0 {
+		// already expired, run on the next tick
+		t.expires = w.current
+		delta = 0
+	}
+	// a timer beyond the wheel waits in the last slot,
+	// then is added again with its own expires
+	expires := t.expires
+	if delta > wheelMaxTicks {
+		expires = w.current + wheelMaxTicks
+		delta = wheelMaxTicks
+	}
+
+	if delta < wheelRootSize {
+		w.root[expires&wheelRootMask].push(t)
+		return
+	}
+	for level := 0; level < wheelLevels; level++ {
+		shift := uint(wheelRootBits + level*wheelLevelBits)
+		if delta < 1<<(shift+wheelLevelBits) || level == wheelLevels-1 {
+			w.levels[level][(expires>>shift)&wheelLevelMask].push(t)
+			return
+		}
+	}
+}
+
+func (w *wheel) afterFunc(t *Timer, d time.Duration) {
+	if d < 0 {
+		d = 0
+	}
+	// tick n runs once n+1 resolutions have passed, never early
+	expires := uint64((time.Since(w.start) + d) / w.resolution)
+
+	w.Lock()
+	t.wheel = w
+	t.expires = expires
+	w.add(t)
+	w.Unlock()
+}
+
+func (w *wheel) remove(t *Timer) {
+	w.Lock()
+	if t.prev != nil {
+		t.prev.next = t.next
+		t.next.prev = t.prev
+		t.prev = nil
+		t.next = nil
+	}
+	w.Unlock()
+}
+
+// runs the current tick, appends the expired timers to w.expired
+// the caller must hold the lock
+func (w *wheel) tick() {
+	index := w.current & wheelRootMask
+	if index == 0 {
+		// refill the root from the levels above
+		for level := 0; level < wheelLevels; level++ {
+			shift := uint(wheelRootBits + level*wheelLevelBits)
+			i := (w.current >> shift) & wheelLevelMask
+			for t := w.levels[level][i].take(); t != nil; {
+				next := t.next
+				t.prev, t.next = nil, nil
+				w.add(t)
+				t = next
+			}
+			if i != 0 {
+				break
+			}
+		}
+	}
+
+	for t := w.root[index].take(); t != nil; {
+		next := t.next
+		t.prev, t.next = nil, nil
+		w.expired = append(w.expired, t)
+		t = next
+	}
+	w.current++
+}
+
+func (w *wheel) run() {
+	defer w.wg.Done()
+
+	ticker := time.NewTicker(w.resolution)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.closeSig:
+			return
+		case now := <-ticker.C:
+			target := uint64(now.Sub(w.start) / w.resolution)
+
+			for {
+				w.Lock()
+				if w.current >= target {
+					w.Unlock()
+					break
+				}
+				w.tick()
+				w.Unlock()
+
+				for i, t := range w.expired {
+					w.expired[i] = nil
+					select {
+					case w.disp.ChanTimer <- t:
+					case <-w.closeSig:
+						return
+					}
+				}
+				w.expired = w.expired[:0]
+			}
+		}
+	}
+}
+
+func (w *wheel) close() {
+	close(w.closeSig)
+	w.wg.Wait()
+}
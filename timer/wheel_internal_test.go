@@ -0,0 +1,112 @@
+package timer
+
+import (
+	"testing"
+	"time"
+)
+
+// the ticks of the wheel are run by the test, a delay of n hours
+// is n ticks
+func newTestWheel() (*Dispatcher, *wheel) {
+	disp := NewWheelDispatcher(10, time.Hour)
+	disp.wheel.close()
+	return disp, disp.wheel
+}
+
+// runTicks runs n ticks and records the tick each timer fired on
+func runTicks(w *wheel, n uint64, fired map[*Timer]uint64) {
+	for i := uint64(0); i < n; i++ {
+		tick := w.current
+		w.Lock()
+		w.tick()
+		w.Unlock()
+		for _, t := range w.expired {
+			if _, ok := fired[t]; ok {
+				panic("timer fired twice")
+			}
+			fired[t] = tick
+		}
+		w.expired = w.expired[:0]
+	}
+	// the new timers count from the current tick
+	w.start = w.start.Add(-time.Duration(n) * w.resolution)
+}
+
+func ticks(n uint64) time.Duration {
+	return time.Duration(n) * time.Hour
+}
+
+func TestWheelLevels(t *testing.T) {
+	disp, w := newTestWheel()
+	fired := make(map[*Timer]uint64)
+	// off the slot boundaries
+	runTicks(w, 100, fired)
+
+	delays := []uint64{
+		0, 1, 155, 156, 157, 255, 256, 257,
+		1<<14 - 1, 1 << 14, 1<<14 + 1,
+		1<<20 - 1, 1 << 20, 1<<20 + 1,
+	}
+	timers := make(map[*Timer]uint64)
+	for _, d := range delays {
+		timers[disp.AfterFunc(ticks(d), func() {})] = w.current + d
+	}
+	runTicks(w, 1<<20+2, fired)
+
+	for tm, want := range timers {
+		if got, ok := fired[tm]; !ok || got != want {
+			t.Errorf("timer of tick %v fired on %v (%v)", want, got, ok)
+		}
+	}
+}
+
+func TestWheelStopCascaded(t *testing.T) {
+	disp, w := newTestWheel()
+	fired := make(map[*Timer]uint64)
+
+	// in the same slot of the first level, moved to the root at tick 768
+	stopped := disp.AfterFunc(ticks(1000), func() {})
+	kept := disp.AfterFunc(ticks(1001), func() {})
+	runTicks(w, 800, fired)
+	stopped.Stop()
+	runTicks(w, 300, fired)
+
+	if tick, ok := fired[stopped]; ok {
+		t.Fatalf("stopped timer fired on %v", tick)
+	}
+	if tick := fired[kept]; tick != 1001 {
+		t.Fatalf("timer of tick 1001 fired on %v", tick)
+	}
+	if stopped.Active() {
+		t.Fatal("stopped timer active")
+	}
+}
+
+func TestWheelReset(t *testing.T) {
+	disp, w := newTestWheel()
+	fired := make(map[*Timer]uint64)
+
+	sooner := disp.AfterFunc(ticks(1000), func() {})
+	later := disp.AfterFunc(ticks(1000), func() {})
+	uncascaded := disp.AfterFunc(ticks(5000), func() {})
+	runTicks(w, 800, fired)
+
+	// cascaded to the root
+	if !sooner.Reset(ticks(10)) {
+		t.Fatal("Reset of a pending timer false")
+	}
+	later.Reset(ticks(500))
+	// still in the first level
+	uncascaded.Reset(ticks(300))
+	runTicks(w, 600, fired)
+
+	want := map[*Timer]uint64{sooner: 810, later: 1300, uncascaded: 1100}
+	for tm, tick := range want {
+		if got, ok := fired[tm]; !ok || got != tick {
+			t.Errorf("timer reset to tick %v fired on %v (%v)", tick, got, ok)
+		}
+	}
+	if len(fired) != len(want) {
+		t.Errorf("%v timers fired, want %v", len(fired), len(want))
+	}
+}
@@ -0,0 +1,57 @@
+package timer_test
+
+import (
+	"gitee.com/aarlin/leaflet/timer"
+	"testing"
+	"time"
+)
+
+func benchmarkDispatchers(b *testing.B, f func(b *testing.B, d *timer.Dispatcher)) {
+	b.Run("std", func(b *testing.B) {
+		f(b, timer.NewDispatcher(1000))
+	})
+	b.Run("wheel", func(b *testing.B) {
+		d := timer.NewWheelDispatcher(1000, time.Millisecond)
+		defer d.Close()
+		f(b, d)
+	})
+}
+
+// buffs and cooldowns are mostly cancelled before they fire
+func BenchmarkAfterFuncStop(b *testing.B) {
+	benchmarkDispatchers(b, func(b *testing.B, d *timer.Dispatcher) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			d.AfterFunc(time.Minute, func() {}).Stop()
+		}
+	})
+}
+
+// 10000 pending timers, each one restarted as it fires
+func BenchmarkAfterFuncFire(b *testing.B) {
+	benchmarkDispatchers(b, func(b *testing.B, d *timer.Dispatcher) {
+		const pending = 10000
+		fired := 0
+		var cb func()
+		cb = func() {
+			fired++
+			d.AfterFunc(time.Millisecond, cb)
+		}
+		for i := 0; i < pending; i++ {
+			d.AfterFunc(time.Duration(i%10)*time.Millisecond, cb)
+		}
+
+		b.ReportAllocs()
+		b.ResetTimer()
+		for fired < b.N {
+			(<-d.ChanTimer).Cb()
+		}
+		b.StopTimer()
+
+		// stop the restarts
+		cb = func() {}
+		for len(d.ChanTimer) > 0 {
+			<-d.ChanTimer
+		}
+	})
+}